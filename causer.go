@@ -0,0 +1,124 @@
+package causer
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/gscienty/causer/expr/checker"
+	"github.com/gscienty/causer/expr/compiler"
+	"github.com/gscienty/causer/expr/optimizer"
+	"github.com/gscienty/causer/expr/parser"
+	"github.com/gscienty/causer/runtime"
+)
+
+type Option func(c *config)
+
+type config struct {
+	env       interface{}
+	functions map[string]interface{}
+	pure      map[string]interface{}
+	operators map[string][]interface{}
+	limits    runtime.Limits
+	debug     func(runtime.Trace)
+}
+
+func Env(env interface{}) Option {
+	return func(c *config) { c.env = env }
+}
+
+func Function(name string, fn interface{}) Option {
+	return func(c *config) { c.functions[name] = fn }
+}
+
+func PureFunction(name string, fn interface{}) Option {
+	return func(c *config) {
+		c.functions[name] = fn
+		c.pure[name] = fn
+	}
+}
+
+func Operator(name string, fn interface{}) Option {
+	return func(c *config) { c.operators[name] = append(c.operators[name], fn) }
+}
+
+func Limits(limits runtime.Limits) Option {
+	return func(c *config) { c.limits = limits }
+}
+
+func Debug(fn func(runtime.Trace)) Option {
+	return func(c *config) { c.debug = fn }
+}
+
+func Compile(source string, options ...Option) (*runtime.Program, error) {
+	c := &config{
+		functions: make(map[string]interface{}),
+		pure:      make(map[string]interface{}),
+		operators: make(map[string][]interface{}),
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	tree, err := parser.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = checker.Check(tree, checker.Config{
+		Env:       c.env,
+		Functions: c.functions,
+		Operators: c.operators,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tree = optimizer.Optimize(tree, optimizer.Config{
+		Functions: c.pure,
+		Operators: c.operators,
+	})
+
+	program, err := compiler.Compile(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	programOptions := []runtime.Option{
+		runtime.WithLimits(c.limits),
+		runtime.WithDebug(c.debug),
+	}
+	if c.env != nil {
+		programOptions = append(programOptions, runtime.WithEnvType(reflect.TypeOf(c.env)))
+	}
+	for name, fn := range c.functions {
+		programOptions = append(programOptions, runtime.WithFunction(name, fn))
+	}
+	for name, impls := range c.operators {
+		for _, impl := range impls {
+			programOptions = append(programOptions, runtime.WithOperator(name, impl))
+		}
+	}
+
+	program = compiler.Peephole(program).With(programOptions...)
+	if err := runtime.Verify(program); err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+func Run(program *runtime.Program, env interface{}) (interface{}, error) {
+	return runtime.Run(program, env)
+}
+
+func RunContext(ctx context.Context, program *runtime.Program, env interface{}) (interface{}, error) {
+	return runtime.RunContext(ctx, program, env)
+}
+
+func Eval(source string, env interface{}, options ...Option) (interface{}, error) {
+	program, err := Compile(source, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return Run(program, env)
+}
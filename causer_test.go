@@ -0,0 +1,175 @@
+package causer
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/gscienty/causer/runtime"
+	"github.com/stretchr/testify/assert"
+)
+
+type patient struct {
+	Age    int
+	Dose   float64
+	Region string
+}
+
+func TestEval(t *testing.T) {
+	ret, err := Eval(`Age >= 65 and Region in ["north", "east"]`, patient{Age: 70, Region: "east"})
+	assert.Nil(t, err)
+	assert.Equal(t, true, ret)
+
+	ret, err = Eval(`dose > 10 ? 1 : 0.5`, map[string]interface{}{"dose": 12})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, ret)
+
+	_, err = Eval(`1 +`, nil)
+	assert.NotNil(t, err)
+}
+
+func TestCompileOptions(t *testing.T) {
+	type money struct{ Cents int }
+
+	program, err := Compile(
+		`scale(Dose) + bonus`,
+		Env(map[string]interface{}{}),
+		Function("scale", func(v float64) money { return money{int(v * 100)} }),
+		Operator("+", func(left, right money) money { return money{left.Cents + right.Cents} }),
+	)
+	assert.Nil(t, err)
+
+	ret, err := Run(program, map[string]interface{}{"Dose": 1.5, "bonus": money{25}})
+	assert.Nil(t, err)
+	assert.Equal(t, money{175}, ret)
+
+	_, err = Run(program, patient{Dose: 1.5})
+	assert.EqualError(t, err, "env of type causer.patient does not match declared map[string]interface {}")
+}
+
+func TestCompileEnvPointer(t *testing.T) {
+	program, err := Compile(`Age * 2`, Env(patient{}))
+	assert.Nil(t, err)
+
+	ret, err := Run(program, &patient{Age: 21})
+	assert.Nil(t, err)
+	assert.Equal(t, 42, ret)
+}
+
+func TestCompileLimits(t *testing.T) {
+	program, err := Compile(`Age + 2 + 3`, Env(patient{}), Limits(runtime.Limits{MaxSteps: 2}))
+	assert.Nil(t, err)
+
+	_, err = Run(program, patient{Age: 21})
+	var rtErr *runtime.Error
+	assert.True(t, errors.As(err, &rtErr))
+	assert.EqualError(t, rtErr.Err, "step limit 2 exceeded")
+
+	for _, source := range []string{`[1, 2, 3, 4, 5]`, `'aaaa' + 'bbbb'`} {
+		_, err = Compile(source, Limits(runtime.Limits{MaxAllocation: 2}))
+		assert.True(t, errors.Is(err, runtime.ErrInvalidProgram), source)
+		assert.True(t, errors.As(err, new(*runtime.AllocationLimitError)), source)
+	}
+}
+
+func TestCompilePureFunction(t *testing.T) {
+	calls := 0
+	scale := func(x float64) float64 {
+		calls++
+		return x * 10
+	}
+
+	program, err := Compile(`Dose * scale(0.5)`, Env(patient{}), PureFunction("scale", scale))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+
+	for i := 0; i < 3; i++ {
+		ret, err := Run(program, patient{Dose: 2})
+		assert.Nil(t, err)
+		assert.Equal(t, 10.0, ret)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestCompileFunctionArguments(t *testing.T) {
+	max := func(x float64, xs ...float64) float64 {
+		for _, v := range xs {
+			x = math.Max(x, v)
+		}
+		return x
+	}
+
+	program, err := Compile(`max(Age, 2, Dose) + sqrt(16)`, Env(patient{}), Function("max", max), PureFunction("sqrt", math.Sqrt))
+	assert.Nil(t, err)
+	ret, err := Run(program, patient{Age: 3, Dose: 5.5})
+	assert.Nil(t, err)
+	assert.Equal(t, 9.5, ret)
+
+	program, err = Compile(`max(Region)`, Env(patient{}), Function("max", max))
+	assert.Nil(t, err)
+	_, err = Run(program, patient{Region: "east"})
+	var argErr *runtime.ArgumentError
+	assert.True(t, errors.As(err, &argErr))
+	assert.Equal(t, "max", argErr.Function)
+	assert.Equal(t, 0, argErr.Index)
+}
+
+func TestRunConstantIsolation(t *testing.T) {
+	program, err := Compile(`[[1, 2], {"a": [3]}]`)
+	assert.Nil(t, err)
+
+	ret, err := Run(program, nil)
+	assert.Nil(t, err)
+	array := ret.([]interface{})
+	array[0].([]interface{})[0] = 99
+	array[1].(map[interface{}]interface{})["a"].([]interface{})[0] = 99
+
+	ret, err = Run(program, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{[]interface{}{1, 2}, map[interface{}]interface{}{"a": []interface{}{3}}}, ret)
+}
+
+func TestRunContext(t *testing.T) {
+	program, err := Compile(`Age > 1`, Env(patient{}))
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = RunContext(ctx, program, patient{Age: 2})
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestCompileDebug(t *testing.T) {
+	var trace runtime.Trace
+	program, err := Compile(`Dose * 2 + Age`, Env(patient{}), Debug(func(t runtime.Trace) { trace = t }))
+	assert.Nil(t, err)
+
+	_, err = Run(program, patient{Age: 1, Dose: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.Trace{Steps: 5, StackDepth: 1, MaxStackDepth: 2}, trace)
+}
+
+func TestCompileMarshal(t *testing.T) {
+	program, err := Compile(`Age >= 65 and Region in ["north", "east"] ? Dose * 0.5 : Dose`, Env(patient{}))
+	assert.Nil(t, err)
+
+	data, err := program.MarshalBinary()
+	assert.Nil(t, err)
+
+	var loaded runtime.Program
+	assert.Nil(t, loaded.UnmarshalBinary(data))
+
+	ret, err := Run(&loaded, patient{Age: 70, Dose: 4, Region: "east"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, ret)
+}
+
+func TestCompileCheck(t *testing.T) {
+	_, err := Compile(`"age" + 3`)
+	assert.EqualError(t, err, "1:1: invalid operation: string + int\n | \"age\" + 3\n | ^~~~~~~~~")
+
+	_, err = Compile(`Age + Weight`, Env(patient{}))
+	assert.EqualError(t, err, "1:7: unknown identifier Weight\n | Age + Weight\n |       ^~~~~~")
+}
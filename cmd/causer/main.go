@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gscienty/causer"
+	"github.com/gscienty/causer/runtime"
+)
+
+const usage = `usage: causer disasm 'expr'`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) != 2 || args[0] != "disasm" {
+		return errors.New(usage)
+	}
+
+	program, err := causer.Compile(args[1])
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprint(out, runtime.Disassemble(program))
+	return err
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDisasm(t *testing.T) {
+	var out bytes.Buffer
+	assert.Nil(t, run([]string{"disasm", "a + 1"}, &out))
+	assert.Equal(t, "0000  Fetch     0  \"a\"  1:1  a\n0003  AddConst  1  1    1:1  a + 1\n", out.String())
+
+	assert.EqualError(t, run([]string{"disasm"}, &out), usage)
+	assert.EqualError(t, run([]string{"disasm", "a +"}, &out), "1:4: unexpect end of expression\n | a +\n |    ^")
+}
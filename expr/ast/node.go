@@ -1,16 +1,38 @@
 package ast
 
-import "reflect"
+import (
+	"reflect"
+
+	"github.com/gscienty/causer/expr/file"
+)
 
 type Node interface {
 	Type() reflect.Type
+	SetType(t reflect.Type)
+	Start() file.Position
+	End() file.Position
+	SetPosition(start, end file.Position)
 }
 
 type base struct {
 	nodeType reflect.Type
+	start    file.Position
+	end      file.Position
 }
 
-func (b *base) Type() reflect.Type { return b.nodeType }
+func (b *base) Type() reflect.Type     { return b.nodeType }
+func (b *base) SetType(t reflect.Type) { b.nodeType = t }
+func (b *base) Start() file.Position   { return b.start }
+func (b *base) End() file.Position     { return b.end }
+
+func (b *base) SetPosition(start, end file.Position) {
+	b.start = start
+	b.end = end
+}
+
+type BadNode struct {
+	base
+}
 
 type UnaryNode struct {
 	base
@@ -25,6 +47,13 @@ type BinaryNode struct {
 	Right    Node
 }
 
+type ConditionalNode struct {
+	base
+	Cond Node
+	Then Node
+	Else Node
+}
+
 type MethodNode struct {
 	base
 	Node      Node
@@ -44,6 +73,19 @@ type PropertyNode struct {
 	Property string
 }
 
+type IndexNode struct {
+	base
+	Node  Node
+	Index Node
+}
+
+type SliceNode struct {
+	base
+	Node Node
+	From Node
+	To   Node
+}
+
 type BoolNode struct {
 	base
 	Value bool
@@ -73,6 +115,28 @@ type StringNode struct {
 	Value string
 }
 
+type ConstantNode struct {
+	base
+	Value interface{}
+}
+
+type ArrayNode struct {
+	base
+	Nodes []Node
+}
+
+type MapNode struct {
+	base
+	Pairs []*PairNode
+}
+
+type PairNode struct {
+	base
+	Key   Node
+	Value Node
+}
+
 type Tree struct {
-	Root Node
+	Root   Node
+	Source string
 }
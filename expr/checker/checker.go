@@ -0,0 +1,485 @@
+package checker
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gscienty/causer/expr/ast"
+	"github.com/gscienty/causer/expr/file"
+	"github.com/gscienty/causer/runtime"
+)
+
+var (
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+	boolType      = reflect.TypeOf(true)
+	intType       = reflect.TypeOf(0)
+	int64Type     = reflect.TypeOf(int64(0))
+	floatType     = reflect.TypeOf(0.0)
+	stringType    = reflect.TypeOf("")
+	timeType      = reflect.TypeOf(time.Time{})
+	arrayType     = reflect.TypeOf([]interface{}{})
+	mapType       = reflect.TypeOf(map[interface{}]interface{}{})
+)
+
+type Config struct {
+	Env       interface{}
+	Functions map[string]interface{}
+	Operators map[string][]interface{}
+}
+
+type checker struct {
+	config Config
+	source string
+	err    error
+}
+
+type signature struct {
+	in       []reflect.Type
+	variadic bool
+	out      reflect.Type
+}
+
+func Check(tree *ast.Tree, config Config) (reflect.Type, error) {
+	c := &checker{config: config, source: tree.Source}
+
+	t := c.check(tree.Root)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return t, nil
+}
+
+func (c *checker) errorf(node ast.Node, format string, args ...interface{}) reflect.Type {
+	if c.err == nil {
+		c.err = &file.Error{
+			Start:   node.Start(),
+			End:     node.End(),
+			Message: fmt.Sprintf(format, args...),
+			Source:  c.source,
+		}
+	}
+	return interfaceType
+}
+
+func (c *checker) check(node ast.Node) reflect.Type {
+	var t reflect.Type
+
+	switch n := node.(type) {
+	case *ast.UnaryNode:
+		t = c.checkUnaryNode(n)
+	case *ast.BinaryNode:
+		t = c.checkBinaryNode(n)
+	case *ast.ConditionalNode:
+		t = c.checkConditionalNode(n)
+	case *ast.MethodNode:
+		t = c.checkMethodNode(n)
+	case *ast.FunctionNode:
+		t = c.checkFunctionNode(n)
+	case *ast.PropertyNode:
+		t = c.checkPropertyNode(n)
+	case *ast.IndexNode:
+		t = c.checkIndexNode(n)
+	case *ast.SliceNode:
+		t = c.checkSliceNode(n)
+	case *ast.IdentifierNode:
+		t = c.checkIdentifierNode(n)
+	case *ast.ArrayNode:
+		for _, item := range n.Nodes {
+			c.check(item)
+		}
+		t = arrayType
+	case *ast.MapNode:
+		for _, pair := range n.Pairs {
+			c.check(pair.Key)
+			c.check(pair.Value)
+			pair.SetType(interfaceType)
+		}
+		t = mapType
+	case *ast.BoolNode:
+		t = boolType
+	case *ast.NilNode:
+		t = interfaceType
+	case *ast.FloatNode:
+		t = floatType
+	case *ast.IntNode:
+		t = intType
+	case *ast.StringNode:
+		t = stringType
+	case *ast.ConstantNode:
+		t = interfaceType
+		if n.Value != nil {
+			t = reflect.TypeOf(n.Value)
+		}
+	case *ast.BadNode:
+		return c.errorf(node, "bad expression")
+	default:
+		return c.errorf(node, "unexpected node %T", node)
+	}
+
+	node.SetType(t)
+	return t
+}
+
+func (c *checker) checkUnaryNode(n *ast.UnaryNode) reflect.Type {
+	t := c.check(n.Expr)
+
+	switch n.Operator {
+	case "not", "!":
+		return boolType
+	case "-", "+":
+		switch {
+		case isUnknown(t):
+			return interfaceType
+		case isUnsigned(t.Kind()) && n.Operator == "-":
+			return int64Type
+		case isNumber(t.Kind()):
+			return t
+		}
+	}
+	return c.errorf(n, "invalid operation: %s%v", n.Operator, t)
+}
+
+func (c *checker) checkBinaryNode(n *ast.BinaryNode) reflect.Type {
+	left := c.check(n.Left)
+	right := c.check(n.Right)
+
+	if t, ok := c.overload(n.Operator, left, right); ok {
+		return t
+	}
+
+	switch n.Operator {
+	case "and", "&&", "or", "||":
+		if left == right {
+			return left
+		}
+		return interfaceType
+
+	case "==", "!=":
+		return boolType
+
+	case "<", ">", "<=", ">=":
+		switch {
+		case isUnknown(left) || isUnknown(right):
+		case isNumber(left.Kind()) && isNumber(right.Kind()):
+		case left.Kind() == reflect.String && right.Kind() == reflect.String:
+		case left == timeType && right == timeType:
+		default:
+			return c.errorf(n, "invalid operation: %v %s %v", left, n.Operator, right)
+		}
+		return boolType
+
+	case "in", "not in":
+		if isUnknown(right) {
+			return boolType
+		}
+		container := right
+		if container.Kind() == reflect.Ptr {
+			container = container.Elem()
+		}
+		switch container.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return boolType
+		case reflect.String, reflect.Struct:
+			if isUnknown(left) || left.Kind() == reflect.String {
+				return boolType
+			}
+		}
+		return c.errorf(n, "invalid operation: %v %s %v", left, n.Operator, right)
+
+	case "+", "-", "*", "/", "%", "^":
+		switch {
+		case isUnknown(left) || isUnknown(right):
+			return interfaceType
+		case n.Operator == "+" && left.Kind() == reflect.String && right.Kind() == reflect.String:
+			return stringType
+		case isNumber(left.Kind()) && isNumber(right.Kind()):
+			if n.Operator == "^" && !isFloat(left.Kind()) && !isFloat(right.Kind()) {
+				return interfaceType
+			}
+			return runtime.Promote(left, right)
+		}
+		return c.errorf(n, "invalid operation: %v %s %v", left, n.Operator, right)
+	}
+
+	return c.errorf(n, "unknown operator %s", n.Operator)
+}
+
+func (c *checker) overload(op string, left, right reflect.Type) (reflect.Type, bool) {
+	if isUnknown(left) || isUnknown(right) {
+		return nil, false
+	}
+
+	for _, impl := range c.config.Operators[op] {
+		if out, ok := runtime.MatchOperator(impl, left, right); ok {
+			return out, true
+		}
+	}
+	return nil, false
+}
+
+func (c *checker) checkConditionalNode(n *ast.ConditionalNode) reflect.Type {
+	c.check(n.Cond)
+	then := c.check(n.Then)
+	otherwise := c.check(n.Else)
+
+	if then == otherwise {
+		return then
+	}
+	return interfaceType
+}
+
+func (c *checker) checkIdentifierNode(n *ast.IdentifierNode) reflect.Type {
+	if c.config.Env == nil {
+		return interfaceType
+	}
+
+	envType := reflect.TypeOf(c.config.Env)
+	if envType.Kind() == reflect.Ptr {
+		envType = envType.Elem()
+	}
+
+	switch envType.Kind() {
+	case reflect.Struct:
+		if field, ok := envType.FieldByName(n.Value); ok {
+			return field.Type
+		}
+	case reflect.Map:
+		env := reflect.ValueOf(c.config.Env)
+		if env.Len() == 0 {
+			return envType.Elem()
+		}
+		if envType.Key().Kind() != reflect.String {
+			return interfaceType
+		}
+		value := env.MapIndex(reflect.ValueOf(n.Value).Convert(envType.Key()))
+		if value.IsValid() {
+			if value.Kind() == reflect.Interface {
+				if value.IsNil() {
+					return interfaceType
+				}
+				return value.Elem().Type()
+			}
+			return value.Type()
+		}
+	default:
+		return interfaceType
+	}
+
+	return c.errorf(n, "unknown identifier %s", n.Value)
+}
+
+func (c *checker) checkPropertyNode(n *ast.PropertyNode) reflect.Type {
+	t := c.check(n.Node)
+	if isUnknown(t) {
+		return interfaceType
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if field, ok := t.FieldByName(n.Property); ok {
+			return field.Type
+		}
+		return c.errorf(n, "unknown property %s of %v", n.Property, t)
+	case reflect.Map:
+		if t.Key().Kind() == reflect.String || t.Key().Kind() == reflect.Interface {
+			return t.Elem()
+		}
+	}
+	return c.errorf(n, "cannot access property %s of %v", n.Property, t)
+}
+
+func (c *checker) checkIndexNode(n *ast.IndexNode) reflect.Type {
+	t := c.check(n.Node)
+	index := c.check(n.Index)
+	if isUnknown(t) {
+		return interfaceType
+	}
+
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.String:
+		if !isUnknown(index) && !isInteger(index.Kind()) && !isUnsigned(index.Kind()) {
+			return c.errorf(n.Index, "invalid index type %v", index)
+		}
+		if t.Kind() == reflect.String {
+			return stringType
+		}
+		return t.Elem()
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		if name, ok := n.Index.(*ast.StringNode); ok {
+			if field, ok := t.FieldByName(name.Value); ok {
+				return field.Type
+			}
+			return c.errorf(n, "unknown property %s of %v", name.Value, t)
+		}
+		return interfaceType
+	}
+	return c.errorf(n, "cannot index %v", t)
+}
+
+func (c *checker) checkSliceNode(n *ast.SliceNode) reflect.Type {
+	t := c.check(n.Node)
+	for _, bound := range []ast.Node{n.From, n.To} {
+		if bound == nil {
+			continue
+		}
+		if b := c.check(bound); !isUnknown(b) && !isInteger(b.Kind()) && !isUnsigned(b.Kind()) {
+			return c.errorf(bound, "invalid slice index type %v", b)
+		}
+	}
+
+	switch {
+	case isUnknown(t):
+		return interfaceType
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.String:
+		return t
+	case t.Kind() == reflect.Array:
+		return reflect.SliceOf(t.Elem())
+	}
+	return c.errorf(n, "cannot slice %v", t)
+}
+
+func (c *checker) checkFunctionNode(n *ast.FunctionNode) reflect.Type {
+	for _, arg := range n.Arguments {
+		c.check(arg)
+	}
+
+	sig, ok := c.function(n.Name)
+	if !ok {
+		return c.errorf(n, "unknown function %s", n.Name)
+	}
+	return c.call(n, n.Name, sig, n.Arguments)
+}
+
+func (c *checker) checkMethodNode(n *ast.MethodNode) reflect.Type {
+	t := c.check(n.Node)
+	for _, arg := range n.Arguments {
+		c.check(arg)
+	}
+
+	sig, ok := c.method(t, n.Method)
+	if !ok {
+		return c.errorf(n, "unknown method %s of %v", n.Method, t)
+	}
+	return c.call(n, n.Method, sig, n.Arguments)
+}
+
+func (c *checker) call(node ast.Node, name string, sig *signature, arguments []ast.Node) reflect.Type {
+	if sig == nil {
+		return interfaceType
+	}
+
+	if sig.variadic {
+		if len(arguments) < len(sig.in)-1 {
+			return c.errorf(node, "wrong number of arguments for %s: expect at least %d, got %d", name, len(sig.in)-1, len(arguments))
+		}
+	} else if len(arguments) != len(sig.in) {
+		return c.errorf(node, "wrong number of arguments for %s: expect %d, got %d", name, len(sig.in), len(arguments))
+	}
+	return sig.out
+}
+
+func (c *checker) function(name string) (*signature, bool) {
+	if fn, ok := c.config.Functions[name]; ok {
+		return newSignature(reflect.TypeOf(fn), 0)
+	}
+	if c.config.Env == nil {
+		return nil, true
+	}
+
+	env := reflect.ValueOf(c.config.Env)
+	if method := env.MethodByName(name); method.IsValid() {
+		return newSignature(method.Type(), 0)
+	}
+
+	if env.Kind() == reflect.Ptr {
+		env = env.Elem()
+	}
+	switch env.Kind() {
+	case reflect.Struct:
+		if field, ok := env.Type().FieldByName(name); ok {
+			return newSignature(field.Type, 0)
+		}
+	case reflect.Map:
+		if env.Len() == 0 || env.Type().Key().Kind() != reflect.String {
+			return newSignature(env.Type().Elem(), 0)
+		}
+		value := env.MapIndex(reflect.ValueOf(name).Convert(env.Type().Key()))
+		if value.IsValid() {
+			if value.Kind() == reflect.Interface && !value.IsNil() {
+				value = value.Elem()
+			}
+			return newSignature(value.Type(), 0)
+		}
+	default:
+		return nil, true
+	}
+	return nil, false
+}
+
+func (c *checker) method(t reflect.Type, name string) (*signature, bool) {
+	if isUnknown(t) && (t == nil || t.NumMethod() == 0) {
+		return nil, true
+	}
+
+	if method, ok := t.MethodByName(name); ok {
+		if t.Kind() == reflect.Interface {
+			return newSignature(method.Type, 0)
+		}
+		return newSignature(method.Type, 1)
+	}
+	if t.Kind() == reflect.Interface {
+		return nil, true
+	}
+	if t.Kind() != reflect.Ptr {
+		if method, ok := reflect.PtrTo(t).MethodByName(name); ok {
+			return newSignature(method.Type, 1)
+		}
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if field, ok := t.FieldByName(name); ok {
+			return newSignature(field.Type, 0)
+		}
+	case reflect.Map:
+		return newSignature(t.Elem(), 0)
+	}
+	return nil, false
+}
+
+func newSignature(fnType reflect.Type, skip int) (*signature, bool) {
+	if isUnknown(fnType) {
+		return nil, true
+	}
+	if fnType.Kind() != reflect.Func {
+		return nil, false
+	}
+
+	sig := &signature{
+		in:       make([]reflect.Type, 0, fnType.NumIn()),
+		variadic: fnType.IsVariadic(),
+		out:      interfaceType,
+	}
+	for i := skip; i < fnType.NumIn(); i++ {
+		sig.in = append(sig.in, fnType.In(i))
+	}
+	if fnType.NumOut() > 0 && fnType.Out(0) != errorType {
+		sig.out = fnType.Out(0)
+	}
+	return sig, true
+}
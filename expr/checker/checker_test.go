@@ -0,0 +1,152 @@
+package checker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gscienty/causer/expr/ast"
+	"github.com/gscienty/causer/expr/file"
+	"github.com/gscienty/causer/expr/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+type unit struct {
+	Age      int
+	Score    float64
+	Region   string
+	Enrolled time.Time
+	Arms     []string
+	Outcomes map[string]float64
+	Scale    func(float64) float64
+}
+
+func (u unit) Outcome(name string) float64           { return u.Outcomes[name] }
+func (u *unit) Covariates(names ...string) []float64 { return nil }
+
+type env struct {
+	Unit  unit
+	Units []*unit
+	Now   time.Time
+	Label string
+}
+
+func (e env) Mean(values []float64) float64 { return 0 }
+
+func TestCheckTypes(t *testing.T) {
+	cases := []struct {
+		source string
+		expect reflect.Type
+	}{
+		{`1 + 2`, reflect.TypeOf(0)},
+		{`1 + 2.5`, reflect.TypeOf(0.0)},
+		{`"a" + Label`, reflect.TypeOf("")},
+		{`Unit.Age >= 65`, reflect.TypeOf(true)},
+		{`Unit.Enrolled < Now`, reflect.TypeOf(true)},
+		{`Unit.Age > 1 and Unit.Score < 2`, reflect.TypeOf(true)},
+		{`Unit.Outcome("y1") - Unit.Outcome("y0")`, reflect.TypeOf(0.0)},
+		{`Unit.Covariates("a", "b")`, reflect.TypeOf([]float64{})},
+		{`Units[0].Covariates()`, reflect.TypeOf([]float64{})},
+		{`Unit.Scale(Unit.Score)`, reflect.TypeOf(0.0)},
+		{`Mean(Unit.Covariates())`, reflect.TypeOf(0.0)},
+		{`Unit.Arms[1:]`, reflect.TypeOf([]string{})},
+		{`Unit.Arms[0]`, reflect.TypeOf("")},
+		{`Unit.Outcomes["y1"]`, reflect.TypeOf(0.0)},
+		{`Unit.Region in ["north"]`, reflect.TypeOf(true)},
+		{`Unit.Age > 1 ? "old" : "young"`, reflect.TypeOf("")},
+		{`-Unit.Age`, reflect.TypeOf(0)},
+		{`not Unit.Age`, reflect.TypeOf(true)},
+	}
+
+	for _, c := range cases {
+		tree, err := parser.Parse(c.source)
+		assert.Nil(t, err)
+
+		ret, err := Check(tree, Config{Env: env{}})
+		assert.Nil(t, err, c.source)
+		assert.Equal(t, c.expect, ret, c.source)
+		assert.Equal(t, c.expect, tree.Root.Type(), c.source)
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	cases := []struct {
+		source string
+		expect string
+	}{
+		{`"age" + 3`, "invalid operation: string + int"},
+		{`Label - 1`, "invalid operation: string - int"},
+		{`Unit.Age < "65"`, "invalid operation: int < string"},
+		{`Missing > 1`, "unknown identifier Missing"},
+		{`Unit.Height`, "unknown property Height of checker.unit"},
+		{`Unit.Outcome()`, "wrong number of arguments for Outcome: expect 1, got 0"},
+		{`Unit.Missing()`, "unknown method Missing of checker.unit"},
+		{`Mean(1, 2)`, "wrong number of arguments for Mean: expect 1, got 2"},
+		{`Median(1)`, "unknown function Median"},
+		{`Unit.Arms["a"]`, "invalid index type string"},
+		{`Unit.Age[0]`, "cannot index int"},
+		{`Unit.Age in Label`, "invalid operation: int in string"},
+		{`-Label`, "invalid operation: -string"},
+	}
+
+	for _, c := range cases {
+		tree, err := parser.Parse(c.source)
+		assert.Nil(t, err)
+
+		_, err = Check(tree, Config{Env: env{}})
+		assert.IsType(t, &file.Error{}, err, c.source)
+		assert.Equal(t, c.expect, err.(*file.Error).Message, c.source)
+	}
+}
+
+func TestCheckMapEnv(t *testing.T) {
+	tree, err := parser.Parse(`age + dose`)
+	assert.Nil(t, err)
+
+	ret, err := Check(tree, Config{Env: map[string]interface{}{"age": 1, "dose": 0.5}})
+	assert.Nil(t, err)
+	assert.Equal(t, reflect.TypeOf(0.0), ret)
+
+	ret, err = Check(tree, Config{Env: map[string]int{}})
+	assert.Nil(t, err)
+	assert.Equal(t, reflect.TypeOf(0), ret)
+
+	_, err = Check(tree, Config{Env: map[string]interface{}{"age": 1}})
+	assert.EqualError(t, err, "1:7: unknown identifier dose\n | age + dose\n |       ^~~~")
+
+	ret, err = Check(tree, Config{})
+	assert.Nil(t, err)
+	assert.Equal(t, reflect.Interface, ret.Kind())
+	assert.Equal(t, reflect.Interface, tree.Root.(*ast.BinaryNode).Left.Type().Kind())
+}
+
+func TestCheckFunctionsOperators(t *testing.T) {
+	type money struct{ Cents int }
+
+	tree, err := parser.Parse(`price(1) + price(2)`)
+	assert.Nil(t, err)
+
+	config := Config{
+		Functions: map[string]interface{}{"price": func(int) money { return money{} }},
+	}
+	_, err = Check(tree, config)
+	assert.Equal(t, "invalid operation: checker.money + checker.money", err.(*file.Error).Message)
+
+	config.Operators = map[string][]interface{}{
+		"+": {func(left, right money) money { return money{} }},
+	}
+	ret, err := Check(tree, config)
+	assert.Nil(t, err)
+	assert.Equal(t, reflect.TypeOf(money{}), ret)
+
+	type celsius float64
+	tree, err = parser.Parse(`x + y`)
+	assert.Nil(t, err)
+	config = Config{
+		Env:       map[string]float64{"x": 1, "y": 2},
+		Operators: map[string][]interface{}{"+": {func(left, right celsius) celsius { return 0 }}},
+	}
+	ret, err = Check(tree, config)
+	assert.Nil(t, err)
+	assert.Equal(t, reflect.TypeOf(0.0), ret)
+}
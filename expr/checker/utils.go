@@ -0,0 +1,29 @@
+package checker
+
+import "reflect"
+
+func isUnknown(t reflect.Type) bool { return t == nil || t.Kind() == reflect.Interface }
+
+func isNumber(kind reflect.Kind) bool {
+	return isInteger(kind) || isUnsigned(kind) || isFloat(kind)
+}
+
+func isInteger(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUnsigned(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func isFloat(kind reflect.Kind) bool {
+	return kind == reflect.Float32 || kind == reflect.Float64
+}
@@ -1,43 +1,80 @@
 package compiler
 
 import (
-	"reflect"
+	"encoding/binary"
+	"fmt"
+	"math"
 
 	"github.com/gscienty/causer/expr/ast"
+	"github.com/gscienty/causer/expr/file"
 	"github.com/gscienty/causer/runtime"
 )
 
-func Compile(tree *ast.Tree) ([]byte, []interface{}, error) {
-	c := compiler{
-		instructions:   make([]byte, 0),
-		constants:      make([]interface{}, 0),
-		constantsIndex: make(map[interface{}]uint16),
-	}
+var maxOperand uint64 = math.MaxUint32
 
+func Compile(tree *ast.Tree) (*runtime.Program, error) {
+	c := newCompiler(tree.Source, false)
 	c.compile(tree.Root)
+	if c.err == nil && c.jumpOverflow {
+		c = newCompiler(tree.Source, true)
+		c.compile(tree.Root)
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
 
-	return c.instructions, c.constants, nil
+	return runtime.NewProgram(
+		c.instructions,
+		c.constants.constants,
+		runtime.WithSource(tree.Source),
+		runtime.WithLocations(c.locations),
+		runtime.WithConstantStats(c.constants.stats()),
+	), nil
+}
+
+func newCompiler(source string, wideJumps bool) *compiler {
+	return &compiler{
+		source:       source,
+		instructions: make([]byte, 0),
+		constants:    newConstantPool(nil, runtime.ConstantStats{}),
+		wideJumps:    wideJumps,
+	}
 }
 
 type compiler struct {
+	source       string
 	instructions []byte
+	locations    []runtime.Location
+	node         ast.Node
+	err          error
+	constants    *constantPool
 
-	constants      []interface{}
-	constantsIndex map[interface{}]uint16
+	wideJumps    bool
+	jumpOverflow bool
 }
 
 func (c *compiler) compile(node ast.Node) {
+	parent := c.node
+	c.node = node
+	defer func() { c.node = parent }()
+
 	switch n := node.(type) {
 	case *ast.UnaryNode:
 		c.compileUnaryNode(n)
 	case *ast.BinaryNode:
 		c.compileBinaryNode(n)
+	case *ast.ConditionalNode:
+		c.compileConditionalNode(n)
 	case *ast.MethodNode:
 		c.compileMethodNode(n)
 	case *ast.FunctionNode:
 		c.compileFunctionNode(n)
 	case *ast.PropertyNode:
 		c.compilePropertyNode(n)
+	case *ast.IndexNode:
+		c.compileIndexNode(n)
+	case *ast.SliceNode:
+		c.compileSliceNode(n)
 	case *ast.IdentifierNode:
 		c.compileIdentifierNode(n)
 	case *ast.BoolNode:
@@ -50,12 +87,43 @@ func (c *compiler) compile(node ast.Node) {
 		c.compileIntNode(n)
 	case *ast.StringNode:
 		c.compileStringNode(n)
+	case *ast.ConstantNode:
+		c.compileConstantNode(n)
+	case *ast.ArrayNode:
+		c.compileArrayNode(n)
+	case *ast.MapNode:
+		c.compileMapNode(n)
+	default:
+		c.errorf("unexpected node %T", node)
 	}
 }
 
-func (c *compiler) appendInstruction(instruction byte, operands ...byte) {
-	c.instructions = append(c.instructions, instruction)
-	c.instructions = append(c.instructions, operands...)
+func (c *compiler) errorf(format string, args ...interface{}) {
+	if c.err != nil {
+		return
+	}
+
+	err := &file.Error{Message: fmt.Sprintf(format, args...), Source: c.source}
+	if c.node != nil {
+		err.Start, err.End = c.node.Start(), c.node.End()
+	}
+	c.err = err
+}
+
+func (c *compiler) appendInstruction(instruction byte, operands ...int) {
+	c.emit(instruction, runtime.IsWide(operands...), operands...)
+}
+
+func (c *compiler) emit(instruction byte, wide bool, operands ...int) {
+	for _, operand := range operands {
+		if uint64(operand) > maxOperand {
+			c.errorf("operand %d of %v exceeds limit %d", operand, runtime.OpCode(instruction), maxOperand)
+			return
+		}
+	}
+
+	c.appendLocation()
+	c.instructions = append(c.instructions, runtime.Encode(instruction, wide, operands...)...)
 }
 
 func (c *compiler) compileUnaryNode(n *ast.UnaryNode) {
@@ -66,10 +134,64 @@ func (c *compiler) compileUnaryNode(n *ast.UnaryNode) {
 		c.appendInstruction(runtime.OpCodeNot)
 	case "-":
 		c.appendInstruction(runtime.OpCodeNegate)
+	default:
+		c.errorf("unknown operator %s", n.Operator)
+	}
+}
+
+func (c *compiler) appendLocation() {
+	if c.node == nil {
+		return
+	}
+
+	start, end := c.node.Start(), c.node.End()
+	if n := len(c.locations); n > 0 && c.locations[n-1].Start == start && c.locations[n-1].End == end {
+		return
+	}
+	c.locations = append(c.locations, runtime.Location{
+		Offset: len(c.instructions),
+		Start:  start,
+		End:    end,
+	})
+}
+
+func (c *compiler) appendJump(instruction byte) int {
+	c.emit(instruction, c.wideJumps, 0)
+	return len(c.instructions)
+}
+
+func (c *compiler) patchJump(pos int) {
+	offset := len(c.instructions) - pos
+	switch {
+	case c.wideJumps && uint64(offset) > maxOperand:
+		c.errorf("jump offset %d exceeds limit %d", offset, maxOperand)
+	case c.wideJumps:
+		binary.BigEndian.PutUint32(c.instructions[pos-4:pos], uint32(offset))
+	case offset > math.MaxUint16:
+		c.jumpOverflow = true
+	default:
+		binary.BigEndian.PutUint16(c.instructions[pos-2:pos], uint16(offset))
 	}
 }
 
+func (c *compiler) compileLogicalNode(n *ast.BinaryNode, jump byte) {
+	c.compile(n.Left)
+	end := c.appendJump(jump)
+	c.appendInstruction(runtime.OpCodePop)
+	c.compile(n.Right)
+	c.patchJump(end)
+}
+
 func (c *compiler) compileBinaryNode(n *ast.BinaryNode) {
+	switch n.Operator {
+	case "and", "&&":
+		c.compileLogicalNode(n, runtime.OpCodeJumpIfFalse)
+		return
+	case "or", "||":
+		c.compileLogicalNode(n, runtime.OpCodeJumpIfTrue)
+		return
+	}
+
 	c.compile(n.Left)
 	c.compile(n.Right)
 
@@ -86,16 +208,47 @@ func (c *compiler) compileBinaryNode(n *ast.BinaryNode) {
 		c.appendInstruction(runtime.OpCodePow)
 	case "%":
 		c.appendInstruction(runtime.OpCodeMod)
+	case "==":
+		c.appendInstruction(runtime.OpCodeEqual)
+	case "!=":
+		c.appendInstruction(runtime.OpCodeNotEqual)
+	case "<":
+		c.appendInstruction(runtime.OpCodeLess)
+	case ">":
+		c.appendInstruction(runtime.OpCodeGreater)
+	case "<=":
+		c.appendInstruction(runtime.OpCodeLessEqual)
+	case ">=":
+		c.appendInstruction(runtime.OpCodeGreaterEqual)
+	case "in":
+		c.appendInstruction(runtime.OpCodeIn)
+	case "not in":
+		c.appendInstruction(runtime.OpCodeIn)
+		c.appendInstruction(runtime.OpCodeNot)
+	default:
+		c.errorf("unknown operator %s", n.Operator)
 	}
 }
 
+func (c *compiler) compileConditionalNode(n *ast.ConditionalNode) {
+	c.compile(n.Cond)
+	otherwise := c.appendJump(runtime.OpCodeJumpIfFalse)
+	c.appendInstruction(runtime.OpCodePop)
+	c.compile(n.Then)
+	end := c.appendJump(runtime.OpCodeJump)
+	c.patchJump(otherwise)
+	c.appendInstruction(runtime.OpCodePop)
+	c.compile(n.Else)
+	c.patchJump(end)
+}
+
 func (c *compiler) compileMethodNode(n *ast.MethodNode) {
 	c.compile(n.Node)
 	for _, arg := range n.Arguments {
 		c.compile(arg)
 	}
 
-	c.appendInstruction(runtime.OpCodeCall, c.newConstant(runtime.Call{Name: n.Method, ArgumentsCnt: len(n.Arguments)})...)
+	c.appendInstruction(runtime.OpCodeMethod, c.newConstant(runtime.Call{Name: n.Method, ArgumentsCnt: len(n.Arguments)}))
 }
 
 func (c *compiler) compileFunctionNode(n *ast.FunctionNode) {
@@ -103,16 +256,37 @@ func (c *compiler) compileFunctionNode(n *ast.FunctionNode) {
 		c.compile(arg)
 	}
 
-	c.appendInstruction(runtime.OpCodeCall, c.newConstant(runtime.Call{Name: n.Name, ArgumentsCnt: len(n.Arguments)})...)
+	c.appendInstruction(runtime.OpCodeCall, c.newConstant(runtime.Call{Name: n.Name, ArgumentsCnt: len(n.Arguments)}))
 }
 
 func (c *compiler) compilePropertyNode(n *ast.PropertyNode) {
 	c.compile(n.Node)
-	c.appendInstruction(runtime.OpCodeProperty, c.newConstant(n.Property)...)
+	c.appendInstruction(runtime.OpCodeProperty, c.newConstant(n.Property))
+}
+
+func (c *compiler) compileIndexNode(n *ast.IndexNode) {
+	c.compile(n.Node)
+	c.compile(n.Index)
+	c.appendInstruction(runtime.OpCodeIndex)
+}
+
+func (c *compiler) compileSliceNode(n *ast.SliceNode) {
+	c.compile(n.Node)
+	if n.From != nil {
+		c.compile(n.From)
+	} else {
+		c.appendInstruction(runtime.OpCodeNil)
+	}
+	if n.To != nil {
+		c.compile(n.To)
+	} else {
+		c.appendInstruction(runtime.OpCodeNil)
+	}
+	c.appendInstruction(runtime.OpCodeSlice)
 }
 
 func (c *compiler) compileIdentifierNode(n *ast.IdentifierNode) {
-	c.appendInstruction(runtime.OpCodeFetch, c.newConstant(n.Value)...)
+	c.appendInstruction(runtime.OpCodeFetch, c.newConstant(n.Value))
 }
 
 func (c *compiler) compileBoolNode(n *ast.BoolNode) {
@@ -124,11 +298,11 @@ func (c *compiler) compileBoolNode(n *ast.BoolNode) {
 }
 
 func (c *compiler) compileFloatNode(n *ast.FloatNode) {
-	c.appendInstruction(runtime.OpCodePush, c.newConstant(n.Value)...)
+	c.appendInstruction(runtime.OpCodePush, c.newConstant(n.Value))
 }
 
 func (c *compiler) compileIntNode(n *ast.IntNode) {
-	c.appendInstruction(runtime.OpCodePush, c.newConstant(n.Value)...)
+	c.appendInstruction(runtime.OpCodePush, c.newConstant(n.Value))
 }
 
 func (c *compiler) compileNilNode(n *ast.NilNode) {
@@ -136,26 +310,39 @@ func (c *compiler) compileNilNode(n *ast.NilNode) {
 }
 
 func (c *compiler) compileStringNode(n *ast.StringNode) {
-	c.appendInstruction(runtime.OpCodePush, c.newConstant(n.Value)...)
+	c.appendInstruction(runtime.OpCodePush, c.newConstant(n.Value))
 }
 
-func (c *compiler) newConstant(i interface{}) []byte {
-	hashable := true
-	switch reflect.TypeOf(i).Kind() {
-	case reflect.Slice, reflect.Map:
-		hashable = false
+func (c *compiler) compileConstantNode(n *ast.ConstantNode) {
+	if n.Value == nil {
+		c.appendInstruction(runtime.OpCodeNil)
+		return
 	}
+	c.appendInstruction(runtime.OpCodePush, c.newConstant(n.Value))
+}
 
-	if hashable {
-		if ret, ok := c.constantsIndex[i]; ok {
-			return encode(ret)
-		}
+func (c *compiler) compileArrayNode(n *ast.ArrayNode) {
+	for _, node := range n.Nodes {
+		c.compile(node)
 	}
 
-	c.constants = append(c.constants, i)
-	if hashable {
-		c.constantsIndex[i] = uint16(len(c.constants) - 1)
+	c.appendInstruction(runtime.OpCodeArray, len(n.Nodes))
+}
+
+func (c *compiler) compileMapNode(n *ast.MapNode) {
+	for _, pair := range n.Pairs {
+		c.compile(pair.Key)
+		c.compile(pair.Value)
 	}
 
-	return encode(uint16(len(c.constants) - 1))
+	c.appendInstruction(runtime.OpCodeMap, len(n.Pairs))
+}
+
+func (c *compiler) newConstant(i interface{}) int {
+	index := c.constants.add(i)
+	if uint64(index) > maxOperand {
+		c.errorf("too many constants, limit is %d", maxOperand+1)
+		return 0
+	}
+	return index
 }
@@ -1,8 +1,13 @@
 package compiler
 
 import (
+	"math/rand"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/gscienty/causer/expr/ast"
+	"github.com/gscienty/causer/expr/file"
 	"github.com/gscienty/causer/expr/parser"
 	"github.com/gscienty/causer/runtime"
 	"github.com/stretchr/testify/assert"
@@ -11,7 +16,7 @@ import (
 func TestCompileSimple(t *testing.T) {
 	tree, err := parser.Parse("1 + 2 - 3")
 	assert.Nil(t, err)
-	inst, constants, err := Compile(tree)
+	program, err := Compile(tree)
 	assert.Nil(t, err)
 
 	expectInst := []byte{
@@ -22,16 +27,16 @@ func TestCompileSimple(t *testing.T) {
 		runtime.OpCodeSub,
 	}
 
-	assert.Equal(t, expectInst, inst)
+	assert.Equal(t, expectInst, program.Instructions())
 
 	expectConstants := []interface{}{1, 2, 3}
-	assert.Equal(t, expectConstants, constants)
+	assert.Equal(t, expectConstants, program.Constants())
 }
 
 func TestCompileCall(t *testing.T) {
 	tree, err := parser.Parse("func(1 + 2 - 3) + func(1)")
 	assert.Nil(t, err)
-	inst, constants, err := Compile(tree)
+	program, err := Compile(tree)
 	assert.Nil(t, err)
 
 	expectInst := []byte{
@@ -46,6 +51,406 @@ func TestCompileCall(t *testing.T) {
 		runtime.OpCodeAdd,
 	}
 
-	assert.Equal(t, 4, len(constants))
-	assert.Equal(t, expectInst, inst)
+	assert.Equal(t, 4, len(program.Constants()))
+	assert.Equal(t, expectInst, program.Instructions())
+}
+
+func TestCompileCompare(t *testing.T) {
+	tree, err := parser.Parse("age >= 65")
+	assert.Nil(t, err)
+	program, err := Compile(tree)
+	assert.Nil(t, err)
+
+	expectInst := []byte{
+		runtime.OpCodeFetch, 0x00, 0x00,
+		runtime.OpCodePush, 0x00, 0x01,
+		runtime.OpCodeGreaterEqual,
+	}
+
+	assert.Equal(t, expectInst, program.Instructions())
+	assert.Equal(t, []interface{}{"age", 65}, program.Constants())
+
+	ret, err := runtime.Run(program, map[string]interface{}{"age": 70})
+	assert.Nil(t, err)
+	assert.Equal(t, true, ret)
+
+	ret, err = runtime.Run(program, map[string]interface{}{"age": 64.5})
+	assert.Nil(t, err)
+	assert.Equal(t, false, ret)
+}
+
+func TestCompileLogical(t *testing.T) {
+	tree, err := parser.Parse("x and x.Score > 0")
+	assert.Nil(t, err)
+	program, err := Compile(tree)
+	assert.Nil(t, err)
+
+	expectInst := []byte{
+		runtime.OpCodeFetch, 0x00, 0x00,
+		runtime.OpCodeJumpIfFalse, 0x00, 0x0b,
+		runtime.OpCodePop,
+		runtime.OpCodeFetch, 0x00, 0x00,
+		runtime.OpCodeProperty, 0x00, 0x01,
+		runtime.OpCodePush, 0x00, 0x02,
+		runtime.OpCodeGreater,
+	}
+	assert.Equal(t, expectInst, program.Instructions())
+
+	type unit struct{ Score int }
+
+	ret, err := runtime.Run(program, map[string]interface{}{"x": nil})
+	assert.Nil(t, err)
+	assert.Nil(t, ret)
+
+	ret, err = runtime.Run(program, map[string]interface{}{"x": &unit{Score: 3}})
+	assert.Nil(t, err)
+	assert.Equal(t, true, ret)
+}
+
+func TestCompileShortCircuit(t *testing.T) {
+	calls := 0
+	env := map[string]interface{}{
+		"yes": func() bool { calls++; return true },
+		"no":  func() bool { calls++; return false },
+	}
+
+	cases := []struct {
+		source string
+		expect bool
+		calls  int
+	}{
+		{"yes() or no()", true, 1},
+		{"no() || yes()", true, 2},
+		{"no() and yes()", false, 1},
+		{"yes() && no()", false, 2},
+		{"no() and yes() or yes()", true, 2},
+	}
+
+	for _, c := range cases {
+		calls = 0
+
+		tree, err := parser.Parse(c.source)
+		assert.Nil(t, err)
+		program, err := Compile(tree)
+		assert.Nil(t, err)
+
+		ret, err := runtime.Run(program, env)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expect, ret, c.source)
+		assert.Equal(t, c.calls, calls, c.source)
+	}
+}
+
+func TestCompileConditional(t *testing.T) {
+	tree, err := parser.Parse("dose > 10 ? high() : low()")
+	assert.Nil(t, err)
+	program, err := Compile(tree)
+	assert.Nil(t, err)
+
+	expectInst := []byte{
+		runtime.OpCodeFetch, 0x00, 0x00,
+		runtime.OpCodePush, 0x00, 0x01,
+		runtime.OpCodeGreater,
+		runtime.OpCodeJumpIfFalse, 0x00, 0x07,
+		runtime.OpCodePop,
+		runtime.OpCodeCall, 0x00, 0x02,
+		runtime.OpCodeJump, 0x00, 0x04,
+		runtime.OpCodePop,
+		runtime.OpCodeCall, 0x00, 0x03,
+	}
+	assert.Equal(t, expectInst, program.Instructions())
+
+	calls := make([]string, 0)
+	env := map[string]interface{}{
+		"high": func() string { calls = append(calls, "high"); return "high" },
+		"low":  func() string { calls = append(calls, "low"); return "low" },
+	}
+
+	env["dose"] = 20
+	ret, err := runtime.Run(program, env)
+	assert.Nil(t, err)
+	assert.Equal(t, "high", ret)
+
+	env["dose"] = 5
+	ret, err = runtime.Run(program, env)
+	assert.Nil(t, err)
+	assert.Equal(t, "low", ret)
+
+	assert.Equal(t, []string{"high", "low"}, calls)
+}
+
+func TestCompileArrayMap(t *testing.T) {
+	tree, err := parser.Parse(`{"treated": [a, 2], "control": []}`)
+	assert.Nil(t, err)
+	program, err := Compile(tree)
+	assert.Nil(t, err)
+
+	expectInst := []byte{
+		runtime.OpCodePush, 0x00, 0x00,
+		runtime.OpCodeFetch, 0x00, 0x01,
+		runtime.OpCodePush, 0x00, 0x02,
+		runtime.OpCodeArray, 0x00, 0x02,
+		runtime.OpCodePush, 0x00, 0x03,
+		runtime.OpCodeArray, 0x00, 0x00,
+		runtime.OpCodeMap, 0x00, 0x02,
+	}
+	assert.Equal(t, expectInst, program.Instructions())
+
+	ret, err := runtime.Run(program, map[string]interface{}{"a": 1.5})
+	assert.Nil(t, err)
+	assert.Equal(t, map[interface{}]interface{}{
+		"treated": []interface{}{1.5, 2},
+		"control": []interface{}{},
+	}, ret)
+}
+
+func TestCompileIn(t *testing.T) {
+	tree, err := parser.Parse(`region not in ["north", "east"]`)
+	assert.Nil(t, err)
+	program, err := Compile(tree)
+	assert.Nil(t, err)
+
+	expectInst := []byte{
+		runtime.OpCodeFetch, 0x00, 0x00,
+		runtime.OpCodePush, 0x00, 0x01,
+		runtime.OpCodePush, 0x00, 0x02,
+		runtime.OpCodeArray, 0x00, 0x02,
+		runtime.OpCodeIn,
+		runtime.OpCodeNot,
+	}
+	assert.Equal(t, expectInst, program.Instructions())
+
+	ret, err := runtime.Run(program, map[string]interface{}{"region": "south"})
+	assert.Nil(t, err)
+	assert.Equal(t, true, ret)
+
+	ret, err = runtime.Run(program, map[string]interface{}{"region": "east"})
+	assert.Nil(t, err)
+	assert.Equal(t, false, ret)
+}
+
+func TestCompileIndexSlice(t *testing.T) {
+	tree, err := parser.Parse(`arms[1:][-1]`)
+	assert.Nil(t, err)
+	program, err := Compile(tree)
+	assert.Nil(t, err)
+
+	expectInst := []byte{
+		runtime.OpCodeFetch, 0x00, 0x00,
+		runtime.OpCodePush, 0x00, 0x01,
+		runtime.OpCodeNil,
+		runtime.OpCodeSlice,
+		runtime.OpCodePush, 0x00, 0x01,
+		runtime.OpCodeNegate,
+		runtime.OpCodeIndex,
+	}
+	assert.Equal(t, expectInst, program.Instructions())
+
+	tree, err = parser.Parse(`arms[1:3][0] + levels["high"]`)
+	assert.Nil(t, err)
+	program, err = Compile(tree)
+	assert.Nil(t, err)
+
+	ret, err := runtime.Run(program, map[string]interface{}{
+		"arms":   []string{"control", "low", "high"},
+		"levels": map[string]string{"high": "+"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "low+", ret)
+}
+
+func TestCompileUnary(t *testing.T) {
+	cases := []struct {
+		source string
+		expect interface{}
+	}{
+		{"-x", -2},
+		{"not true", false},
+		{"!false and x > 0", true},
+		{"-x ^ 2", 4},
+		{"y == nil", true},
+	}
+
+	for _, c := range cases {
+		tree, err := parser.Parse(c.source)
+		assert.Nil(t, err)
+		program, err := Compile(tree)
+		assert.Nil(t, err)
+
+		ret, err := runtime.Run(program, map[string]interface{}{"x": 2, "y": nil})
+		assert.Nil(t, err, c.source)
+		assert.Equal(t, c.expect, ret, c.source)
+	}
+}
+
+type testUnit struct {
+	Outcomes map[string]float64
+	Weight   float64
+	Scale    func(float64) float64
+}
+
+func (u testUnit) Outcome(name string) float64   { return u.Outcomes[name] }
+func (u *testUnit) Weighted(name string) float64 { return u.Outcomes[name] * u.Weight }
+
+type testOutcomer interface {
+	Outcome(name string) float64
+}
+
+func TestCompileMethod(t *testing.T) {
+	tree, err := parser.Parse(`unit.Outcome("y1")`)
+	assert.Nil(t, err)
+	program, err := Compile(tree)
+	assert.Nil(t, err)
+
+	expectInst := []byte{
+		runtime.OpCodeFetch, 0x00, 0x00,
+		runtime.OpCodePush, 0x00, 0x01,
+		runtime.OpCodeMethod, 0x00, 0x02,
+	}
+	assert.Equal(t, expectInst, program.Instructions())
+
+	unit := testUnit{
+		Outcomes: map[string]float64{"y1": 2, "y0": 1},
+		Weight:   0.5,
+		Scale:    func(v float64) float64 { return v * 10 },
+	}
+
+	cases := []struct {
+		source string
+		env    interface{}
+		expect interface{}
+	}{
+		{`unit.Outcome("y1") - unit.Outcome("y0")`, map[string]interface{}{"unit": unit}, 1.0},
+		{`unit.Outcome("y1")`, map[string]interface{}{"unit": &unit}, 2.0},
+		{`unit.Weighted("y1")`, map[string]interface{}{"unit": unit}, 1.0},
+		{`unit.Weighted("y1")`, map[string]interface{}{"unit": &unit}, 1.0},
+		{`unit.Outcome("y0")`, map[string]testOutcomer{"unit": unit}, 1.0},
+		{`unit.Scale(unit.Weight)`, map[string]interface{}{"unit": unit}, 5.0},
+		{`units[0].Outcome("y1")`, map[string]interface{}{"units": []testOutcomer{&unit}}, 2.0},
+	}
+
+	for _, c := range cases {
+		tree, err := parser.Parse(c.source)
+		assert.Nil(t, err)
+		program, err := Compile(tree)
+		assert.Nil(t, err)
+
+		ret, err := runtime.Run(program, c.env)
+		assert.Nil(t, err, c.source)
+		assert.Equal(t, c.expect, ret, c.source)
+	}
+
+	tree, err = parser.Parse(`unit.Missing()`)
+	assert.Nil(t, err)
+	program, err = Compile(tree)
+	assert.Nil(t, err)
+
+	_, err = runtime.Run(program, map[string]interface{}{"unit": unit})
+	assert.NotNil(t, err)
+}
+
+func TestCompileLocations(t *testing.T) {
+	tree, err := parser.Parse("a +\n b[5]")
+	assert.Nil(t, err)
+	program, err := Compile(tree)
+	assert.Nil(t, err)
+
+	location, ok := program.Location(9)
+	assert.True(t, ok)
+	assert.Equal(t, file.Position{Line: 2, Offset: 1}, location.Start)
+	assert.Equal(t, file.Position{Line: 2, Offset: 5}, location.End)
+
+	_, err = runtime.Run(program, map[string]interface{}{"a": 1, "b": []int{1}})
+	assert.EqualError(t, err, "2:2: index out of range [5] with length 1 (opcode Index at 9, stack depth 1)\n |  b[5]\n |  ^~~~")
+}
+
+func TestCompileNeverPanics(t *testing.T) {
+	alphabet := []string{"a", "1", "2.5", "'s'", "(", ")", "[", "]", "{", "}", ",", ":", "?", ".", "+", "-", "*", "^", "not", "in", "and", "==", "f", "#", " "}
+
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		var source strings.Builder
+		for j := random.Intn(12); j >= 0; j-- {
+			source.WriteString(alphabet[random.Intn(len(alphabet))])
+			source.WriteString(" ")
+		}
+
+		tree, _ := parser.Parse(source.String())
+		assert.NotPanics(t, func() {
+			if program, err := Compile(tree); err == nil {
+				assert.Nil(t, runtime.Verify(program), source.String())
+				assert.Nil(t, runtime.Verify(Peephole(program)), source.String())
+			}
+		}, source.String())
+	}
+}
+
+func TestCompileBadNode(t *testing.T) {
+	tree, err := parser.Parse("1 + )")
+	assert.NotNil(t, err)
+
+	program, err := Compile(tree)
+	assert.Nil(t, program)
+	assert.EqualError(t, err, "1:5: unexpected node *ast.BadNode\n | 1 + )\n |     ^")
+}
+
+func TestCompileUnknownOperator(t *testing.T) {
+	tree, err := parser.Parse("1 + 2")
+	assert.Nil(t, err)
+	tree.Root.(*ast.BinaryNode).Operator = "<>"
+
+	program, err := Compile(tree)
+	assert.Nil(t, program)
+	assert.EqualError(t, err, "1:1: unknown operator <>\n | 1 + 2\n | ^~~~~")
+
+	tree, err = parser.Parse("-x")
+	assert.Nil(t, err)
+	tree.Root.(*ast.UnaryNode).Operator = "~"
+
+	program, err = Compile(tree)
+	assert.Nil(t, program)
+	assert.EqualError(t, err, "1:1: unknown operator ~\n | -x\n | ^~")
+}
+
+func TestCompileWideOperands(t *testing.T) {
+	items := make([]string, 70000)
+	for i := range items {
+		items[i] = strconv.Itoa(i)
+	}
+	source := "x ? [" + strings.Join(items, ", ") + "][x] : -1"
+
+	tree, err := parser.Parse(source)
+	assert.Nil(t, err)
+	program, err := Compile(tree)
+	assert.Nil(t, err)
+	assert.Nil(t, runtime.Verify(program))
+
+	code := program.Instructions()
+	assert.Equal(t, []byte{runtime.OpCodeFetch, 0x00, 0x00, runtime.OpCodeWide, runtime.OpCodeJumpIfFalse}, code[:5])
+	assert.Regexp(t, `Push\.wide +70000 +69999`, runtime.Disassemble(program))
+
+	for _, program := range []*runtime.Program{program, Peephole(program)} {
+		ret, err := runtime.Run(program, map[string]int{"x": 69999})
+		assert.Nil(t, err)
+		assert.Equal(t, 69999, ret)
+
+		ret, err = runtime.Run(program, map[string]int{"x": 0})
+		assert.Nil(t, err)
+		assert.Equal(t, -1, ret)
+	}
+}
+
+func TestCompileOperandOverflow(t *testing.T) {
+	defer func(limit uint64) { maxOperand = limit }(maxOperand)
+	maxOperand = 3
+
+	tree, err := parser.Parse("[1, 2, 3, 4, 5]")
+	assert.Nil(t, err)
+	_, err = Compile(tree)
+	assert.EqualError(t, err, "1:14: too many constants, limit is 4\n | [1, 2, 3, 4, 5]\n |              ^")
+
+	tree, err = parser.Parse("[true, true, true, true]")
+	assert.Nil(t, err)
+	_, err = Compile(tree)
+	assert.EqualError(t, err, "1:1: operand 4 of Array exceeds limit 3\n | [true, true, true, true]\n | ^~~~~~~~~~~~~~~~~~~~~~~~")
 }
@@ -0,0 +1,179 @@
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gscienty/causer/runtime"
+)
+
+type constantKey struct {
+	typ   reflect.Type
+	value interface{}
+}
+
+type constantPool struct {
+	constants []interface{}
+	index     map[constantKey]int
+	strings   map[string]string
+	lookups   int
+	hits      int
+}
+
+func newConstantPool(constants []interface{}, stats runtime.ConstantStats) *constantPool {
+	p := &constantPool{
+		constants: make([]interface{}, 0, len(constants)),
+		index:     make(map[constantKey]int),
+		strings:   make(map[string]string),
+		lookups:   stats.Lookups,
+		hits:      stats.Hits,
+	}
+
+	for _, constant := range constants {
+		constant = p.intern(constant)
+		if key, ok := p.key(constant); ok {
+			if _, exists := p.index[key]; !exists {
+				p.index[key] = len(p.constants)
+			}
+		}
+		p.constants = append(p.constants, constant)
+	}
+	return p
+}
+
+func (p *constantPool) add(constant interface{}) int {
+	p.lookups++
+
+	key, ok := p.key(constant)
+	if ok {
+		if index, exists := p.index[key]; exists {
+			p.hits++
+			return index
+		}
+		p.index[key] = len(p.constants)
+	}
+
+	p.constants = append(p.constants, p.intern(constant))
+	return len(p.constants) - 1
+}
+
+func (p *constantPool) get(index int) interface{} {
+	if index < len(p.constants) {
+		return p.constants[index]
+	}
+	return nil
+}
+
+func (p *constantPool) stats() runtime.ConstantStats {
+	stats := runtime.ConstantStats{
+		Constants: len(p.constants),
+		Strings:   len(p.strings),
+		Lookups:   p.lookups,
+		Hits:      p.hits,
+	}
+	for s := range p.strings {
+		stats.StringBytes += len(s)
+	}
+	for _, constant := range p.constants {
+		switch constant.(type) {
+		case []interface{}, map[interface{}]interface{}:
+			stats.Composites++
+		}
+	}
+	return stats
+}
+
+func (p *constantPool) intern(constant interface{}) interface{} {
+	switch v := constant.(type) {
+	case string:
+		return p.internString(v)
+	case runtime.Call:
+		v.Name = p.internString(v.Name)
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = p.intern(item)
+		}
+	case map[interface{}]interface{}:
+		for key, value := range v {
+			v[key] = p.intern(value)
+		}
+	}
+	return constant
+}
+
+func (p *constantPool) internString(s string) string {
+	if interned, ok := p.strings[s]; ok {
+		return interned
+	}
+	p.strings[s] = s
+	return s
+}
+
+// Floats are keyed by their bits so that NaN constants dedup and 0.0 stays
+// apart from -0.0; composites are keyed by a canonical typed encoding.
+func (p *constantPool) key(constant interface{}) (constantKey, bool) {
+	key := constantKey{typ: reflect.TypeOf(constant), value: constant}
+	switch v := constant.(type) {
+	case float32:
+		key.value = math.Float32bits(v)
+	case float64:
+		key.value = math.Float64bits(v)
+	case []interface{}, map[interface{}]interface{}:
+		var b strings.Builder
+		if !writeConstantKey(&b, v) {
+			return key, false
+		}
+		key.value = b.String()
+	default:
+		if key.typ != nil && !key.typ.Comparable() {
+			return key, false
+		}
+	}
+	return key, true
+}
+
+func writeConstantKey(b *strings.Builder, constant interface{}) bool {
+	switch v := constant.(type) {
+	case nil:
+		b.WriteString("nil")
+	case string:
+		fmt.Fprintf(b, "string:%q", v)
+	case float32:
+		fmt.Fprintf(b, "float32:%x", math.Float32bits(v))
+	case float64:
+		fmt.Fprintf(b, "float64:%x", math.Float64bits(v))
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		fmt.Fprintf(b, "%T:%v", v, v)
+	case []interface{}:
+		b.WriteString("[")
+		for _, item := range v {
+			if !writeConstantKey(b, item) {
+				return false
+			}
+			b.WriteString(",")
+		}
+		b.WriteString("]")
+	case map[interface{}]interface{}:
+		pairs := make([]string, 0, len(v))
+		for key, value := range v {
+			var pair strings.Builder
+			if !writeConstantKey(&pair, key) {
+				return false
+			}
+			pair.WriteString(":")
+			if !writeConstantKey(&pair, value) {
+				return false
+			}
+			pairs = append(pairs, pair.String())
+		}
+		sort.Strings(pairs)
+		b.WriteString("{" + strings.Join(pairs, ",") + "}")
+	default:
+		return false
+	}
+	return true
+}
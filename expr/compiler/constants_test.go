@@ -0,0 +1,80 @@
+package compiler
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gscienty/causer/expr/ast"
+	"github.com/gscienty/causer/expr/parser"
+	"github.com/gscienty/causer/runtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConstantPool(t *testing.T) {
+	p := newConstantPool(nil, runtime.ConstantStats{})
+
+	assert.Equal(t, 0, p.add(1))
+	assert.Equal(t, 1, p.add(1.0))
+	assert.Equal(t, 2, p.add("1"))
+	assert.Equal(t, 3, p.add(int64(1)))
+	assert.Equal(t, 0, p.add(1))
+	assert.Equal(t, 1, p.add(1.0))
+
+	assert.Equal(t, 4, p.add(math.NaN()))
+	assert.Equal(t, 4, p.add(math.NaN()))
+	assert.Equal(t, 5, p.add(0.0))
+	assert.Equal(t, 6, p.add(math.Copysign(0, -1)))
+
+	assert.Equal(t, 7, p.add(runtime.Call{Name: "f", ArgumentsCnt: 1}))
+	assert.Equal(t, 8, p.add(runtime.Call{Name: "f", ArgumentsCnt: 2}))
+	assert.Equal(t, 7, p.add(runtime.Call{Name: "f", ArgumentsCnt: 1}))
+
+	assert.Equal(t, 9, p.add([]interface{}{1, "a", []interface{}{2.0}}))
+	assert.Equal(t, 9, p.add([]interface{}{1, "a", []interface{}{2.0}}))
+	assert.Equal(t, 10, p.add([]interface{}{1, "a", []interface{}{2}}))
+	assert.Equal(t, 11, p.add([]interface{}{"a", "b"}))
+	assert.Equal(t, 12, p.add(map[interface{}]interface{}{"a": 1, 2: "b"}))
+	assert.Equal(t, 12, p.add(map[interface{}]interface{}{2: "b", "a": 1}))
+	assert.Equal(t, 13, p.add(map[interface{}]interface{}{"a": 1.0, 2: "b"}))
+
+	assert.Equal(t, runtime.ConstantStats{
+		Constants:   14,
+		Strings:     4,
+		StringBytes: 4,
+		Composites:  5,
+		Lookups:     20,
+		Hits:        6,
+	}, p.stats())
+}
+
+func TestConstantPoolSeed(t *testing.T) {
+	p := newConstantPool([]interface{}{"a", 1, "a"}, runtime.ConstantStats{Lookups: 3, Hits: 1})
+	assert.Equal(t, []interface{}{"a", 1, "a"}, p.constants)
+	assert.Equal(t, 0, p.add("a"))
+	assert.Equal(t, 3, p.add(1.0))
+	assert.Equal(t, runtime.ConstantStats{Constants: 4, Strings: 1, StringBytes: 1, Lookups: 5, Hits: 2}, p.stats())
+}
+
+func TestCompileConstants(t *testing.T) {
+	tree, err := parser.Parse("x == 1 or x == 1.0 or name == 'x' or [1, 2] == [1, 2]")
+	assert.Nil(t, err)
+	program, err := Compile(tree)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"x", 1, 1.0, "name", 2}, program.Constants())
+	assert.Equal(t, 5, program.ConstantStats().Constants)
+
+	folded := []interface{}{1, 2}
+	tree.Root = &ast.BinaryNode{
+		Operator: "==",
+		Left:     &ast.ConstantNode{Value: folded},
+		Right:    &ast.ConstantNode{Value: []interface{}{1, 2}},
+	}
+	program, err = Compile(tree)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{folded}, program.Constants())
+	assert.Equal(t, runtime.ConstantStats{Constants: 1, Composites: 1, Lookups: 2, Hits: 1}, program.ConstantStats())
+
+	ret, err := runtime.Run(program, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, true, ret)
+}
@@ -0,0 +1,145 @@
+package compiler
+
+import (
+	"encoding/binary"
+
+	"github.com/gscienty/causer/runtime"
+)
+
+type instruction struct {
+	runtime.Instruction
+	location int
+}
+
+func Peephole(program *runtime.Program) *runtime.Program {
+	code := program.Instructions()
+
+	instructions := make([]instruction, 0)
+	targets := make(map[int]bool)
+	for offset := 0; offset < len(code); {
+		inst, err := runtime.Decode(code, offset)
+		if err != nil {
+			return program
+		}
+
+		if inst.IsJump() {
+			targets[inst.Target()] = true
+		}
+		instructions = append(instructions, instruction{Instruction: inst, location: offset})
+		offset += inst.Width
+	}
+
+	p := &peephole{
+		constants: newConstantPool(program.Constants(), program.ConstantStats()),
+		index:     make(map[int]int),
+	}
+
+	for i := 0; i < len(instructions); i++ {
+		inst := instructions[i]
+
+		switch inst.OpCode {
+		case runtime.OpCodeFetch:
+			name := p.constant(inst)
+			if _, ok := name.(string); !ok {
+				break
+			}
+			path := []interface{}{name}
+			last := inst.Offset
+			for i+1 < len(instructions) && instructions[i+1].OpCode == runtime.OpCodeProperty && !targets[instructions[i+1].Offset] {
+				prop := p.constant(instructions[i+1])
+				if _, ok := prop.(string); !ok {
+					break
+				}
+				i++
+				path = append(path, prop)
+				last = instructions[i].Offset
+			}
+			if len(path) > 1 {
+				inst = p.fuse(inst, runtime.OpCodeFetchPath, last, p.constants.add(path))
+			}
+
+		case runtime.OpCodePush:
+			if i+1 >= len(instructions) || targets[instructions[i+1].Offset] {
+				break
+			}
+			next := instructions[i+1]
+			switch next.OpCode {
+			case runtime.OpCodeAdd:
+				inst = p.fuse(inst, runtime.OpCodeAddConst, next.Offset, inst.Operands[0])
+				i++
+			case runtime.OpCodeEqual, runtime.OpCodeNotEqual, runtime.OpCodeLess, runtime.OpCodeGreater, runtime.OpCodeLessEqual, runtime.OpCodeGreaterEqual:
+				inst = p.fuse(inst, runtime.OpCodeCompareConst, next.Offset, inst.Operands[0], int(next.OpCode))
+				i++
+			}
+		}
+
+		p.append(inst)
+	}
+	p.index[len(code)] = len(p.code)
+
+	for _, jump := range p.jumps {
+		offset := p.index[jump.target] - jump.end
+		if jump.wide {
+			binary.BigEndian.PutUint32(p.code[jump.end-4:jump.end], uint32(offset))
+		} else {
+			binary.BigEndian.PutUint16(p.code[jump.end-2:jump.end], uint16(offset))
+		}
+	}
+
+	locations := make([]runtime.Location, 0)
+	for _, inst := range p.emitted {
+		location, ok := program.Location(inst.location)
+		if !ok {
+			continue
+		}
+		if n := len(locations); n > 0 && locations[n-1].Start == location.Start && locations[n-1].End == location.End {
+			continue
+		}
+		location.Offset = inst.Offset
+		locations = append(locations, location)
+	}
+
+	return program.With(
+		runtime.WithInstructions(p.code, p.constants.constants),
+		runtime.WithLocations(locations),
+		runtime.WithConstantStats(p.constants.stats()),
+	)
+}
+
+type jump struct {
+	target int
+	end    int
+	wide   bool
+}
+
+type peephole struct {
+	code      []byte
+	constants *constantPool
+	index     map[int]int
+	emitted   []instruction
+	jumps     []jump
+}
+
+func (p *peephole) constant(inst instruction) interface{} {
+	return p.constants.get(inst.Operands[0])
+}
+
+func (p *peephole) fuse(first instruction, op byte, location int, operands ...int) instruction {
+	return instruction{
+		Instruction: runtime.Instruction{OpCode: op, Wide: runtime.IsWide(operands...), Operands: operands, Offset: first.Offset},
+		location:    location,
+	}
+}
+
+func (p *peephole) append(inst instruction) {
+	offset := len(p.code)
+	p.index[inst.Offset] = offset
+
+	p.code = append(p.code, runtime.Encode(inst.OpCode, inst.Wide, inst.Operands...)...)
+	if inst.IsJump() {
+		p.jumps = append(p.jumps, jump{target: inst.Target(), end: len(p.code), wide: inst.Wide})
+	}
+
+	inst.Offset = offset
+	p.emitted = append(p.emitted, inst)
+}
@@ -0,0 +1,106 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/gscienty/causer/expr/parser"
+	"github.com/gscienty/causer/runtime"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPatient struct {
+	Age       int
+	Dose      float64
+	Region    string
+	Treatment testTreatment
+}
+
+type testTreatment struct {
+	Arm    string
+	Weight float64
+}
+
+func compilePeephole(t testing.TB, source string) (*runtime.Program, *runtime.Program) {
+	tree, err := parser.Parse(source)
+	assert.Nil(t, err)
+	program, err := Compile(tree)
+	assert.Nil(t, err)
+
+	return program, Peephole(program)
+}
+
+func TestPeephole(t *testing.T) {
+	_, program := compilePeephole(t, "p.Treatment.Weight + 1 > 2")
+
+	expectInst := []byte{
+		runtime.OpCodeFetchPath, 0x00, 0x05,
+		runtime.OpCodeAddConst, 0x00, 0x03,
+		runtime.OpCodeCompareConst, 0x00, 0x04, runtime.OpCodeGreater,
+	}
+	assert.Equal(t, expectInst, program.Instructions())
+	assert.Equal(t, []interface{}{"p", "Treatment", "Weight"}, program.Constants()[5])
+}
+
+func TestPeepholeEquivalence(t *testing.T) {
+	env := map[string]interface{}{
+		"p": testPatient{Age: 70, Dose: 2, Region: "east", Treatment: testTreatment{Arm: "a", Weight: 0.5}},
+		"x": 3,
+	}
+
+	sources := []string{
+		"p.Age >= 65 ? p.Dose * 0.5 : p.Dose",
+		"p.Region in ['north', 'east'] and p.Treatment.Arm == 'a'",
+		"p.Age < 18 or p.Treatment.Weight + 0.5 != 1",
+		"x + 1 + 2 <= 6 ? x + 10 : -1",
+		"p.Age > 80 and p.Dose + 1 > 0 or x == 3",
+		"[p.Age + 1, x + 1][1]",
+	}
+
+	for _, source := range sources {
+		program, optimized := compilePeephole(t, source)
+		assert.Less(t, len(optimized.Instructions()), len(program.Instructions()), source)
+
+		expect, err := runtime.Run(program, env)
+		assert.Nil(t, err, source)
+		ret, err := runtime.Run(optimized, env)
+		assert.Nil(t, err, source)
+		assert.Equal(t, expect, ret, source)
+	}
+}
+
+func TestPeepholeLocations(t *testing.T) {
+	_, program := compilePeephole(t, "x > 1 and\n p.Missing + 1 > 0")
+
+	_, err := runtime.Run(program, map[string]interface{}{"x": 2, "p": testPatient{}})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, err.(*runtime.Error).Line)
+	assert.Equal(t, 2, err.(*runtime.Error).Column)
+}
+
+func TestPeepholeAllocs(t *testing.T) {
+	env := map[string]interface{}{"p": testPatient{Treatment: testTreatment{Arm: "a"}}}
+	program, optimized := compilePeephole(t, "p.Treatment.Arm == 'a'")
+
+	allocs := func(program *runtime.Program) float64 {
+		return testing.AllocsPerRun(100, func() { runtime.Run(program, env) })
+	}
+	assert.LessOrEqual(t, allocs(optimized), allocs(program))
+}
+
+func BenchmarkPeephole(b *testing.B) {
+	env := map[string]interface{}{
+		"p": testPatient{Age: 70, Dose: 2, Region: "east", Treatment: testTreatment{Arm: "a", Weight: 0.5}},
+	}
+	source := "p.Age >= 65 and p.Treatment.Arm == 'a' ? p.Dose * p.Treatment.Weight + 1 : p.Dose + 0.5"
+
+	program, optimized := compilePeephole(b, source)
+	for name, program := range map[string]*runtime.Program{"plain": program, "peephole": optimized} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := runtime.Run(program, env); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package file
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Position struct {
+	Line   int
+	Offset int
+}
+
+func (p Position) String() string { return fmt.Sprintf("%d:%d", p.Line, p.Offset+1) }
+
+type Error struct {
+	Start   Position
+	End     Position
+	Message string
+	Source  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %s%s", e.Start, e.Message, Snippet(e.Source, e.Start, e.End))
+}
+
+func Snippet(source string, start, end Position) string {
+	lines := strings.Split(source, "\n")
+	if start.Line < 1 || start.Line > len(lines) {
+		return ""
+	}
+
+	line := []rune(strings.TrimRight(lines[start.Line-1], "\r"))
+	if start.Offset < 0 || start.Offset > len(line) {
+		return ""
+	}
+
+	width := 1
+	if end.Line == start.Line && end.Offset > start.Offset {
+		width = end.Offset - start.Offset
+	}
+	if rest := len(line) - start.Offset; width > rest && rest > 0 {
+		width = rest
+	}
+
+	pad := make([]rune, start.Offset)
+	for i := range pad {
+		pad[i] = ' '
+		if line[i] == '\t' {
+			pad[i] = '\t'
+		}
+	}
+
+	return fmt.Sprintf("\n | %s\n | %s^%s", string(line), string(pad), strings.Repeat("~", width-1))
+}
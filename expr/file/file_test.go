@@ -0,0 +1,24 @@
+package file
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorSnippet(t *testing.T) {
+	err := &Error{
+		Start:   Position{Line: 2, Offset: 2},
+		End:     Position{Line: 2, Offset: 5},
+		Message: "unknown identifier foo",
+		Source:  "1 +\n\t+ foo",
+	}
+	assert.EqualError(t, err, "2:3: unknown identifier foo\n | \t+ foo\n | \t ^~~")
+}
+
+func TestSnippetOutOfRange(t *testing.T) {
+	assert.Equal(t, "", Snippet("a", Position{Line: 3}, Position{Line: 3}))
+	assert.Equal(t, "\n | a\n |  ^", Snippet("a", Position{Line: 1, Offset: 1}, Position{Line: 1, Offset: 1}))
+	assert.Equal(t, "", Snippet("a", Position{Line: 1, Offset: -5}, Position{Line: 1, Offset: 1}))
+	assert.Equal(t, "\n | ab\n | ^~", Snippet("ab", Position{Line: 1, Offset: 0}, Position{Line: 1, Offset: 1 << 30}))
+}
@@ -0,0 +1,285 @@
+package optimizer
+
+import (
+	"reflect"
+
+	"github.com/gscienty/causer/expr/ast"
+	"github.com/gscienty/causer/expr/compiler"
+	"github.com/gscienty/causer/runtime"
+)
+
+type Config struct {
+	Functions map[string]interface{}
+	Operators map[string][]interface{}
+}
+
+func Optimize(tree *ast.Tree, config Config) *ast.Tree {
+	o := &optimizer{source: tree.Source, config: config}
+	tree.Root = o.optimize(tree.Root)
+	return tree
+}
+
+type optimizer struct {
+	source string
+	config Config
+}
+
+func (o *optimizer) optimize(node ast.Node) ast.Node {
+	switch n := node.(type) {
+	case *ast.UnaryNode:
+		n.Expr = o.optimize(n.Expr)
+		if isConstant(n.Expr) {
+			return o.fold(n)
+		}
+
+	case *ast.BinaryNode:
+		n.Left = o.optimize(n.Left)
+		n.Right = o.optimize(n.Right)
+		return o.optimizeBinaryNode(n)
+
+	case *ast.ConditionalNode:
+		n.Cond = o.optimize(n.Cond)
+		n.Then = o.optimize(n.Then)
+		n.Else = o.optimize(n.Else)
+		if isConstant(n.Cond) {
+			if runtime.Truthy(o.value(n.Cond)) {
+				return n.Then
+			}
+			return n.Else
+		}
+
+	case *ast.MethodNode:
+		n.Node = o.optimize(n.Node)
+		for i, arg := range n.Arguments {
+			n.Arguments[i] = o.optimize(arg)
+		}
+
+	case *ast.FunctionNode:
+		constant := true
+		for i, arg := range n.Arguments {
+			n.Arguments[i] = o.optimize(arg)
+			constant = constant && isConstant(n.Arguments[i])
+		}
+		if _, ok := o.config.Functions[n.Name]; ok && constant {
+			return o.fold(n)
+		}
+
+	case *ast.PropertyNode:
+		n.Node = o.optimize(n.Node)
+		if isConstant(n.Node) {
+			return o.fold(n)
+		}
+
+	case *ast.IndexNode:
+		n.Node = o.optimize(n.Node)
+		n.Index = o.optimize(n.Index)
+		if isConstant(n.Node) && isConstant(n.Index) {
+			return o.fold(n)
+		}
+
+	case *ast.SliceNode:
+		n.Node = o.optimize(n.Node)
+		if n.From != nil {
+			n.From = o.optimize(n.From)
+		}
+		if n.To != nil {
+			n.To = o.optimize(n.To)
+		}
+		if isConstant(n.Node) && (n.From == nil || isConstant(n.From)) && (n.To == nil || isConstant(n.To)) {
+			return o.fold(n)
+		}
+
+	case *ast.ArrayNode:
+		constant := true
+		for i, item := range n.Nodes {
+			n.Nodes[i] = o.optimize(item)
+			constant = constant && isConstant(n.Nodes[i])
+		}
+		if constant {
+			return o.fold(n)
+		}
+
+	case *ast.MapNode:
+		constant := true
+		for _, pair := range n.Pairs {
+			pair.Key = o.optimize(pair.Key)
+			pair.Value = o.optimize(pair.Value)
+			constant = constant && isConstant(pair.Key) && isConstant(pair.Value)
+		}
+		if constant {
+			return o.fold(n)
+		}
+	}
+
+	return node
+}
+
+func (o *optimizer) optimizeBinaryNode(n *ast.BinaryNode) ast.Node {
+	switch n.Operator {
+	case "and", "&&":
+		if isConstant(n.Left) {
+			if runtime.Truthy(o.value(n.Left)) {
+				return n.Right
+			}
+			return n.Left
+		}
+		return n
+	case "or", "||":
+		if isConstant(n.Left) {
+			if runtime.Truthy(o.value(n.Left)) {
+				return n.Left
+			}
+			return n.Right
+		}
+		return n
+	}
+
+	if isConstant(n.Left) && isConstant(n.Right) {
+		return o.fold(n)
+	}
+
+	if o.overloaded(n) {
+		return n
+	}
+
+	switch n.Operator {
+	case "+":
+		if isNumber(n.Right, 0) && isSame(n, n.Left) {
+			return n.Left
+		}
+		if isNumber(n.Left, 0) && isSame(n, n.Right) {
+			return n.Right
+		}
+	case "-":
+		if isNumber(n.Right, 0) && isSame(n, n.Left) {
+			return n.Left
+		}
+	case "*":
+		if isNumber(n.Right, 1) && isSame(n, n.Left) {
+			return n.Left
+		}
+		if isNumber(n.Left, 1) && isSame(n, n.Right) {
+			return n.Right
+		}
+	case "/":
+		if isNumber(n.Right, 1) && isSame(n, n.Left) {
+			return n.Left
+		}
+	}
+
+	return n
+}
+
+func (o *optimizer) overloaded(n *ast.BinaryNode) bool {
+	left, right := n.Left.Type(), n.Right.Type()
+	if left == nil || right == nil {
+		return len(o.config.Operators[n.Operator]) > 0
+	}
+	for _, impl := range o.config.Operators[n.Operator] {
+		if _, ok := runtime.MatchOperator(impl, left, right); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *optimizer) fold(node ast.Node) ast.Node {
+	program, err := compiler.Compile(&ast.Tree{Root: node, Source: o.source})
+	if err != nil {
+		return node
+	}
+
+	options := make([]runtime.Option, 0)
+	for name, fn := range o.config.Functions {
+		options = append(options, runtime.WithFunction(name, fn))
+	}
+	for name, impls := range o.config.Operators {
+		for _, impl := range impls {
+			options = append(options, runtime.WithOperator(name, impl))
+		}
+	}
+
+	value, err := runtime.Run(program.With(options...), nil)
+	if err != nil {
+		return node
+	}
+
+	var folded ast.Node
+	switch v := value.(type) {
+	case nil:
+		folded = &ast.NilNode{}
+	case bool:
+		folded = &ast.BoolNode{Value: v}
+	case int:
+		folded = &ast.IntNode{Value: v}
+	case float64:
+		folded = &ast.FloatNode{Value: v}
+	case string:
+		folded = &ast.StringNode{Value: v}
+	case []interface{}, map[interface{}]interface{}:
+		folded = &ast.ConstantNode{Value: v}
+	default:
+		switch reflect.TypeOf(v).Kind() {
+		case reflect.Slice, reflect.Map, reflect.Ptr, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+			return node
+		}
+		folded = &ast.ConstantNode{Value: v}
+	}
+
+	folded.SetPosition(node.Start(), node.End())
+	if value != nil {
+		folded.SetType(reflect.TypeOf(value))
+	} else {
+		folded.SetType(node.Type())
+	}
+	return folded
+}
+
+func (o *optimizer) value(node ast.Node) interface{} {
+	switch n := node.(type) {
+	case *ast.BoolNode:
+		return n.Value
+	case *ast.IntNode:
+		return n.Value
+	case *ast.FloatNode:
+		return n.Value
+	case *ast.StringNode:
+		return n.Value
+	case *ast.ConstantNode:
+		return n.Value
+	}
+	return nil
+}
+
+func isConstant(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.BoolNode, *ast.NilNode, *ast.IntNode, *ast.FloatNode, *ast.StringNode, *ast.ConstantNode:
+		return true
+	}
+	return false
+}
+
+func isNumber(node ast.Node, value float64) bool {
+	switch n := node.(type) {
+	case *ast.IntNode:
+		return float64(n.Value) == value
+	case *ast.FloatNode:
+		return n.Value == value
+	}
+	return false
+}
+
+func isSame(n ast.Node, operand ast.Node) bool {
+	t := n.Type()
+	if t == nil || t != operand.Type() {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package optimizer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gscienty/causer/expr/ast"
+	"github.com/gscienty/causer/expr/checker"
+	"github.com/gscienty/causer/expr/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func optimize(t *testing.T, source string, env interface{}, config Config) ast.Node {
+	tree, err := parser.Parse(source)
+	assert.Nil(t, err)
+
+	_, err = checker.Check(tree, checker.Config{Env: env, Functions: config.Functions, Operators: config.Operators})
+	assert.Nil(t, err)
+
+	return Optimize(tree, config).Root
+}
+
+func TestOptimizeFold(t *testing.T) {
+	tests := []struct {
+		source string
+		expect interface{}
+	}{
+		{"2 * 3.5 + 1", 8.0},
+		{"7 / 2", 3},
+		{"2 ^ 10", 1024},
+		{"1 < 2 and 'a' + 'b' == 'ab'", true},
+		{"not (1 in [1, 2])", false},
+		{"true ? 'yes' : 'no'", "yes"},
+		{"-(1 + 2)", -3},
+		{"[1, 2, 3][-1]", 3},
+	}
+
+	for _, test := range tests {
+		node := optimize(t, test.source, nil, Config{})
+		switch n := node.(type) {
+		case *ast.IntNode:
+			assert.Equal(t, test.expect, n.Value, test.source)
+		case *ast.FloatNode:
+			assert.Equal(t, test.expect, n.Value, test.source)
+		case *ast.StringNode:
+			assert.Equal(t, test.expect, n.Value, test.source)
+		case *ast.BoolNode:
+			assert.Equal(t, test.expect, n.Value, test.source)
+		default:
+			assert.Fail(t, "not folded", "%s: %T", test.source, node)
+		}
+	}
+}
+
+func TestOptimizeComposite(t *testing.T) {
+	node := optimize(t, "x in [1, 1 + 1, 3]", map[string]int{}, Config{})
+
+	binary, ok := node.(*ast.BinaryNode)
+	assert.True(t, ok)
+	constant, ok := binary.Right.(*ast.ConstantNode)
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{1, 2, 3}, constant.Value)
+	assert.Equal(t, 1, constant.Start().Line)
+	assert.Equal(t, 5, constant.Start().Offset)
+}
+
+func TestOptimizeIdentity(t *testing.T) {
+	env := map[string]float64{}
+
+	node := optimize(t, "x * 1 + 0", env, Config{})
+	assert.IsType(t, &ast.IdentifierNode{}, node)
+
+	node = optimize(t, "1 * (x - 0) / 1", env, Config{})
+	assert.IsType(t, &ast.IdentifierNode{}, node)
+
+	node = optimize(t, "false or x", env, Config{})
+	assert.IsType(t, &ast.IdentifierNode{}, node)
+
+	node = optimize(t, "x * 0", env, Config{})
+	assert.IsType(t, &ast.BinaryNode{}, node)
+
+	node = optimize(t, "x * 1", map[string]int8{}, Config{})
+	assert.IsType(t, &ast.BinaryNode{}, node)
+
+	node = optimize(t, "x + 0.0", map[string]float64{}, Config{Operators: map[string][]interface{}{
+		"+": {func(a, b float64) float64 { return a - b }},
+	}})
+	assert.IsType(t, &ast.BinaryNode{}, node)
+
+	type celsius float64
+	node = optimize(t, "x + 0", map[string]float64{}, Config{Operators: map[string][]interface{}{
+		"+": {func(a, b celsius) celsius { return a - b }},
+	}})
+	assert.IsType(t, &ast.IdentifierNode{}, node)
+}
+
+func TestOptimizeFunctions(t *testing.T) {
+	config := Config{Functions: map[string]interface{}{"sqrt": math.Sqrt}}
+
+	node := optimize(t, "sqrt(16.0) + x", map[string]float64{}, config)
+	binary, ok := node.(*ast.BinaryNode)
+	assert.True(t, ok)
+	assert.Equal(t, 4.0, binary.Left.(*ast.FloatNode).Value)
+
+	config = Config{Functions: map[string]interface{}{"arms": func() []string { return []string{"a"} }}}
+	node = optimize(t, "arms()", nil, config)
+	assert.IsType(t, &ast.FunctionNode{}, node)
+
+	node = optimize(t, "1 / 0", nil, Config{})
+	assert.IsType(t, &ast.BinaryNode{}, node)
+}
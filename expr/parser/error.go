@@ -0,0 +1,36 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gscienty/causer/expr/file"
+)
+
+type ErrorList []*file.Error
+
+func (l *ErrorList) add(err *file.Error) {
+	for _, e := range *l {
+		if e.Start == err.Start {
+			return
+		}
+	}
+	*l = append(*l, err)
+}
+
+func (l ErrorList) sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		if l[i].Start.Line != l[j].Start.Line {
+			return l[i].Start.Line < l[j].Start.Line
+		}
+		return l[i].Start.Offset < l[j].Start.Offset
+	})
+}
+
+func (l ErrorList) Error() string {
+	messages := make([]string, len(l))
+	for i, err := range l {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strings"
 	"unicode/utf8"
+
+	"github.com/gscienty/causer/expr/file"
 )
 
 type lexer struct {
@@ -15,7 +17,7 @@ type lexer struct {
 	startPos Position
 	locPos   Position
 	prevPos  Position
-	err      error
+	errors   ErrorList
 }
 
 type lexerStateFunc func(l *lexer) lexerStateFunc
@@ -32,6 +34,7 @@ var keywords []string = []string{
 func (l *lexer) nextAlpha() rune {
 	alpha, width := l.peekAlpha()
 	if alpha == eof {
+		l.prevPos = l.locPos
 		return eof
 	}
 
@@ -78,18 +81,21 @@ func (l *lexer) scanEscape(quote rune) rune {
 	case 'a', 'b', 'f', 'n', 'r', 't', 'v', '\\', quote:
 		alpha = l.nextAlpha()
 	default:
-		l.err = fmt.Errorf("unexpected escape")
+		l.error("unexpected escape")
 	}
 
 	return alpha
 }
 
-func (l *lexer) scanString(quote rune) {
+func (l *lexer) scanString(quote rune) bool {
 	alpha := l.nextAlpha()
 	for alpha != quote {
 		if alpha == '\n' || alpha == eof {
-			l.err = fmt.Errorf("unexpected terminated")
-			return
+			if alpha == '\n' {
+				l.prevAlpha()
+			}
+			l.error("unexpected terminated")
+			return false
 		}
 
 		if alpha == '\\' {
@@ -98,6 +104,7 @@ func (l *lexer) scanString(quote rune) {
 			alpha = l.nextAlpha()
 		}
 	}
+	return true
 }
 
 func (l *lexer) accept(valid string) bool {
@@ -136,10 +143,20 @@ func (l *lexer) scanNumber() bool {
 	return true
 }
 
+func (l *lexer) error(format string, args ...interface{}) {
+	l.errors.add(&file.Error{
+		Start:   l.startPos,
+		End:     l.locPos,
+		Message: fmt.Sprintf(format, args...),
+		Source:  l.source,
+	})
+}
+
 func (l *lexer) productEOF() {
 	l.tokens = append(l.tokens, Token{
 		Kind:     TokenKindEOF,
-		Position: l.prevPos,
+		Position: l.locPos,
+		End:      l.locPos,
 	})
 
 	l.start = l.end
@@ -149,6 +166,7 @@ func (l *lexer) product(kind Kind, word string) {
 	l.tokens = append(l.tokens, Token{
 		Kind:     kind,
 		Position: l.startPos,
+		End:      l.locPos,
 		Value:    word,
 	})
 
@@ -164,10 +182,13 @@ func rootState(l *lexer) lexerStateFunc {
 	case isSpace(alpha):
 		l.ignore()
 	case alpha == '\'' || alpha == '"':
-		l.scanString(alpha)
+		if !l.scanString(alpha) {
+			l.product(TokenKindString, l.word()[1:])
+			break
+		}
 		word, err := unescape(l.word())
 		if err != nil {
-			l.err = err
+			l.error("%v", err)
 		}
 		l.product(TokenKindString, word)
 	case '0' <= alpha && alpha <= '9':
@@ -188,6 +209,9 @@ func rootState(l *lexer) lexerStateFunc {
 	case isAlphaNumeric(alpha):
 		l.prevAlpha()
 		return identifierState
+	default:
+		l.error("unexpected character %q", alpha)
+		l.ignore()
 	}
 
 	return rootState
@@ -234,7 +258,7 @@ func dotState(l *lexer) lexerStateFunc {
 
 func numberState(l *lexer) lexerStateFunc {
 	if !l.scanNumber() {
-		l.err = fmt.Errorf("bad number syntax: %q", l.word())
+		l.error("bad number syntax: %q", l.word())
 		return nil
 	}
 
@@ -251,12 +275,12 @@ func Lexer(source string) ([]Token, error) {
 		prevPos:  Position{Line: 1, Offset: 0},
 	}
 
-	for state := rootState; state != nil && l.err == nil; {
+	for state := rootState; state != nil; {
 		state = state(l)
 	}
 
-	if l.err != nil {
-		return nil, l.err
+	if len(l.errors) > 0 {
+		return l.tokens, l.errors
 	}
 	return l.tokens, nil
 }
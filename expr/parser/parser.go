@@ -2,17 +2,21 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/gscienty/causer/expr/ast"
+	"github.com/gscienty/causer/expr/file"
 	"github.com/spf13/cast"
 )
 
 type parser struct {
+	source  string
 	tokens  []Token
 	current Token
+	last    Position
 	pos     int
-	err     error
+	errors  ErrorList
 }
 
 type associativity string
@@ -50,38 +54,72 @@ var binaryOp = map[string]operator{
 	"-":   {4, associateLeft},
 	"*":   {6, associateLeft},
 	"/":   {6, associateLeft},
-	"^":   {7, associateLeft},
+	"%":   {6, associateLeft},
+	"^":   {7, associateRight},
+
+	"not in": {3, associateLeft},
 }
 
 func Parse(source string) (*ast.Tree, error) {
 	tokens, err := Lexer(source)
-	if err != nil {
-		return nil, err
-	}
 
-	p := &parser{tokens: tokens, current: tokens[0]}
+	p := &parser{source: source, tokens: tokens, current: tokens[0]}
+	if errors, ok := err.(ErrorList); ok {
+		p.errors = errors
+	}
 
 	node := p.parse(0)
 
 	if p.current.Kind != TokenKindEOF {
-		p.err = fmt.Errorf("unexpected token %v", p.current)
+		p.error(p.current, "unexpected token %v", p.current.Value)
 	}
 
-	if p.err != nil {
-		return nil, p.err
+	tree := &ast.Tree{Root: node, Source: source}
+	if len(p.errors) > 0 {
+		p.errors.sort()
+		return tree, p.errors
 	}
 
-	return &ast.Tree{Root: node}, nil
+	return tree, nil
+}
+
+func (p *parser) error(token Token, format string, args ...interface{}) {
+	p.errors.add(&file.Error{
+		Start:   token.Position,
+		End:     token.End,
+		Message: fmt.Sprintf(format, args...),
+		Source:  p.source,
+	})
+}
+
+func (p *parser) mark(node ast.Node, start Position) ast.Node {
+	node.SetPosition(start, p.last)
+	return node
+}
+
+func (p *parser) bad(start Position) ast.Node {
+	return p.mark(&ast.BadNode{}, start)
 }
 
 func (p *parser) parse(priority int) ast.Node {
+	start := p.current.Position
 	nodeLeft := p.parsePrimary()
 
 	token := p.current
-	for token.Kind == TokenKindOperator && p.err == nil {
-		if op, ok := binaryOp[token.Value]; ok {
+	for token.Kind == TokenKindOperator {
+		operator := token.Value
+		if operator == "not" && p.pos+1 < len(p.tokens) {
+			if next := p.tokens[p.pos+1]; next.Kind == TokenKindOperator && next.Value == "in" {
+				operator = "not in"
+			}
+		}
+
+		if op, ok := binaryOp[operator]; ok {
 			if op.priority >= priority {
 				p.next()
+				if operator == "not in" {
+					p.next()
+				}
 
 				var nodeRight ast.Node
 				if op.associate == associateLeft {
@@ -90,11 +128,11 @@ func (p *parser) parse(priority int) ast.Node {
 					nodeRight = p.parse(op.priority)
 				}
 
-				nodeLeft = &ast.BinaryNode{
-					Operator: token.Value,
+				nodeLeft = p.mark(&ast.BinaryNode{
+					Operator: operator,
 					Left:     nodeLeft,
 					Right:    nodeRight,
-				}
+				}, start)
 
 				token = p.current
 				continue
@@ -103,8 +141,24 @@ func (p *parser) parse(priority int) ast.Node {
 		break
 	}
 
-	if priority == 0 {
-		// TODO
+	if priority == 0 && p.is(TokenKindOperator, "?") {
+		p.next()
+		nodeThen := p.parse(0)
+
+		var nodeElse ast.Node
+		if p.is(TokenKindOperator, ":") {
+			p.next()
+			nodeElse = p.parse(0)
+		} else {
+			p.error(p.current, "expect :")
+			nodeElse = p.bad(p.current.Position)
+		}
+
+		nodeLeft = p.mark(&ast.ConditionalNode{
+			Cond: nodeLeft,
+			Then: nodeThen,
+			Else: nodeElse,
+		}, start)
 	}
 
 	return nodeLeft
@@ -117,20 +171,32 @@ func (p *parser) parsePrimary() ast.Node {
 		if op, ok := unaryOp[token.Value]; ok {
 			p.next()
 			expr := p.parse(op.priority)
-			node := &ast.UnaryNode{
+			node := p.mark(&ast.UnaryNode{
 				Operator: token.Value,
 				Expr:     expr,
-			}
+			}, token.Position)
 
-			return p.parsePostfix(node)
+			return p.parsePostfix(node, token.Position)
 		}
 	}
 
 	if token.Kind == TokenKindBracket && token.Value == "(" {
 		p.next()
 		expr := p.parse(0)
+		p.expect(")")
+		return p.parsePostfix(expr, token.Position)
+	}
+
+	if token.Kind == TokenKindBracket && token.Value == "[" {
+		p.next()
+		node := p.mark(&ast.ArrayNode{Nodes: p.parseNodes("]")}, token.Position)
+		return p.parsePostfix(node, token.Position)
+	}
+
+	if token.Kind == TokenKindBracket && token.Value == "{" {
 		p.next()
-		return p.parsePostfix(expr)
+		node := p.parseMap(token.Position)
+		return p.parsePostfix(node, token.Position)
 	}
 
 	switch token.Kind {
@@ -138,91 +204,172 @@ func (p *parser) parsePrimary() ast.Node {
 		p.next()
 		switch token.Value {
 		case "true":
-			return &ast.BoolNode{Value: true}
+			return p.mark(&ast.BoolNode{Value: true}, token.Position)
 		case "false":
-			return &ast.BoolNode{Value: false}
+			return p.mark(&ast.BoolNode{Value: false}, token.Position)
 		case "nil":
-			return &ast.NilNode{}
+			return p.mark(&ast.NilNode{}, token.Position)
 		default:
 			node := p.parseIdentifier(token)
-			return p.parsePostfix(node)
+			return p.parsePostfix(node, token.Position)
 		}
 
 	case TokenKindNumber:
 		p.next()
 		value := strings.ReplaceAll(token.Value, "_", "")
 		if strings.ContainsAny(value, ".") {
-			return &ast.FloatNode{Value: cast.ToFloat64(value)}
+			v, err := cast.ToFloat64E(value)
+			if err != nil {
+				p.error(token, "invalid number %s", token.Value)
+			}
+			return p.parsePostfix(p.mark(&ast.FloatNode{Value: v}, token.Position), token.Position)
 		} else {
-			return &ast.IntNode{Value: cast.ToInt(value)}
+			v, err := strconv.ParseInt(value, 0, 64)
+			if err != nil {
+				p.error(token, "invalid number %s", token.Value)
+			}
+			return p.parsePostfix(p.mark(&ast.IntNode{Value: int(v)}, token.Position), token.Position)
 		}
 
 	case TokenKindString:
 		p.next()
-		return &ast.StringNode{Value: token.Value}
+		node := p.mark(&ast.StringNode{Value: token.Value}, token.Position)
+		return p.parsePostfix(node, token.Position)
+
+	case TokenKindEOF:
+		p.error(token, "unexpect end of expression")
 
 	default:
-		// TODO
+		p.error(token, "unexpected token %v", token.Value)
+		if !p.isSync() {
+			p.next()
+		}
 	}
 
-	return nil
+	return p.bad(token.Position)
 }
 
 func (p *parser) parseIdentifier(token Token) ast.Node {
 	if p.current.Kind == TokenKindBracket && p.current.Value == "(" {
 		p.next()
 		arguments := p.parseArguments()
-		return &ast.FunctionNode{
+		return p.mark(&ast.FunctionNode{
 			Name:      token.Value,
 			Arguments: arguments,
-		}
+		}, token.Position)
 	} else {
-		return &ast.IdentifierNode{Value: token.Value}
+		return p.mark(&ast.IdentifierNode{Value: token.Value}, token.Position)
 	}
 }
 
 func (p *parser) next() {
-	p.pos++
-	if p.pos >= len(p.tokens) {
-		p.err = fmt.Errorf("unexpect end of expression")
+	if p.current.Kind == TokenKindEOF {
 		return
 	}
 
+	p.last = p.current.End
+	p.pos++
 	p.current = p.tokens[p.pos]
 }
 
-func (p *parser) parsePostfix(node ast.Node) ast.Node {
+func (p *parser) expect(closing string) {
+	if p.is(TokenKindBracket, closing) {
+		p.next()
+		return
+	}
+
+	p.error(p.current, "expect %s", closing)
+	p.sync(closing)
+	if p.is(TokenKindBracket, closing) {
+		p.next()
+	}
+}
+
+func (p *parser) isSync() bool {
+	switch p.current.Kind {
+	case TokenKindEOF:
+		return true
+	case TokenKindBracket:
+		return strings.Contains(")]}", p.current.Value)
+	case TokenKindOperator:
+		return p.current.Value == "," || p.current.Value == ":"
+	}
+	return false
+}
+
+func (p *parser) sync(closing string) {
+	depth := 0
+	for p.current.Kind != TokenKindEOF {
+		if p.current.Kind == TokenKindBracket {
+			switch {
+			case strings.Contains("([{", p.current.Value):
+				depth++
+			case depth > 0:
+				depth--
+			case p.current.Value == closing:
+				return
+			}
+		} else if depth == 0 && p.is(TokenKindOperator, ",") {
+			return
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parsePostfix(node ast.Node, start Position) ast.Node {
 	token := p.current
-	for (token.Kind == TokenKindOperator || token.Kind == TokenKindBracket) && p.err == nil {
+	for token.Kind == TokenKindOperator || token.Kind == TokenKindBracket {
 		if token.Value == "." {
 			p.next()
 			token = p.current
-			p.next()
-
 			if token.Kind != TokenKindIdentifier {
-				p.err = fmt.Errorf("expect name")
+				p.error(token, "expect name")
+				break
 			}
+			p.next()
 
 			if p.current.Kind == TokenKindBracket && p.current.Value == "(" {
 				p.next()
 				args := p.parseArguments()
-				node = &ast.MethodNode{
+				node = p.mark(&ast.MethodNode{
 					Node:      node,
 					Method:    token.Value,
 					Arguments: args,
-				}
+				}, start)
 			} else {
-				node = &ast.PropertyNode{
+				node = p.mark(&ast.PropertyNode{
 					Node:     node,
 					Property: token.Value,
-				}
+				}, start)
 			}
-		} else if p.current.Kind == TokenKindBracket && p.current.Value == "(" {
+		} else if token.Kind == TokenKindBracket && token.Value == "[" {
 			p.next()
-			args := p.parseArguments()
-			node = &ast.FunctionNode{
-				Name:      token.Value,
-				Arguments: args,
+
+			var from, to ast.Node
+			if !p.is(TokenKindOperator, ":") {
+				from = p.parse(0)
+			}
+
+			isSlice := p.is(TokenKindOperator, ":")
+			if isSlice {
+				p.next()
+				if !p.is(TokenKindBracket, "]") {
+					to = p.parse(0)
+				}
+			}
+			p.expect("]")
+
+			if isSlice {
+				node = p.mark(&ast.SliceNode{
+					Node: node,
+					From: from,
+					To:   to,
+				}, start)
+			} else {
+				node = p.mark(&ast.IndexNode{
+					Node:  node,
+					Index: from,
+				}, start)
 			}
 		} else {
 			break
@@ -233,18 +380,73 @@ func (p *parser) parsePostfix(node ast.Node) ast.Node {
 	return node
 }
 
-func (p *parser) parseArguments() []ast.Node {
+func (p *parser) is(kind Kind, value string) bool {
+	return p.current.Kind == kind && p.current.Value == value
+}
+
+func (p *parser) parseArguments() []ast.Node { return p.parseNodes(")") }
+
+func (p *parser) parseNodes(closing string) []ast.Node {
 	nodes := make([]ast.Node, 0)
-	for !(p.current.Kind == TokenKindBracket && p.current.Value == ")") && p.err == nil {
+	for !p.is(TokenKindBracket, closing) && p.current.Kind != TokenKindEOF {
 		if len(nodes) > 0 {
-			if !(p.current.Kind == TokenKindOperator && p.current.Value == ",") {
-				p.err = fmt.Errorf("invalid token")
+			if !p.is(TokenKindOperator, ",") {
+				p.error(p.current, "expect , or %s", closing)
+				p.sync(closing)
+				continue
+			}
+			p.next()
+			if p.is(TokenKindBracket, closing) {
+				break
 			}
 		}
 		node := p.parse(0)
 		nodes = append(nodes, node)
 	}
-	p.next()
+	p.expect(closing)
 
 	return nodes
 }
+
+func (p *parser) parseMap(start Position) ast.Node {
+	pairs := make([]*ast.PairNode, 0)
+	for !p.is(TokenKindBracket, "}") && p.current.Kind != TokenKindEOF {
+		if len(pairs) > 0 {
+			if !p.is(TokenKindOperator, ",") {
+				p.error(p.current, "expect , or }")
+				p.sync("}")
+				continue
+			}
+			p.next()
+			if p.is(TokenKindBracket, "}") {
+				break
+			}
+		}
+
+		pairStart := p.current.Position
+
+		var key ast.Node
+		switch p.current.Kind {
+		case TokenKindIdentifier, TokenKindString:
+			p.next()
+			key = p.mark(&ast.StringNode{Value: p.tokens[p.pos-1].Value}, pairStart)
+		default:
+			key = p.parsePrimary()
+		}
+
+		var value ast.Node
+		if p.is(TokenKindOperator, ":") {
+			p.next()
+			value = p.parse(0)
+		} else {
+			p.error(p.current, "expect :")
+			p.sync("}")
+			value = p.bad(p.current.Position)
+		}
+
+		pairs = append(pairs, p.mark(&ast.PairNode{Key: key, Value: value}, pairStart).(*ast.PairNode))
+	}
+	p.expect("}")
+
+	return p.mark(&ast.MapNode{Pairs: pairs}, start)
+}
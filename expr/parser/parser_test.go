@@ -24,3 +24,234 @@ func TestParseSimple(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, "/", binaryOp.Operator)
 }
+
+func TestParseConditional(t *testing.T) {
+	root, err := Parse("a > 1 ? b : c ? d : e + 1")
+	assert.Nil(t, err)
+
+	cond, ok := root.Root.(*ast.ConditionalNode)
+	assert.True(t, ok)
+	assert.Equal(t, ">", cond.Cond.(*ast.BinaryNode).Operator)
+	assert.Equal(t, "b", cond.Then.(*ast.IdentifierNode).Value)
+
+	cond, ok = cond.Else.(*ast.ConditionalNode)
+	assert.True(t, ok)
+	assert.Equal(t, "c", cond.Cond.(*ast.IdentifierNode).Value)
+	assert.Equal(t, "d", cond.Then.(*ast.IdentifierNode).Value)
+	assert.Equal(t, "+", cond.Else.(*ast.BinaryNode).Operator)
+
+	_, err = Parse("a ? b")
+	assert.NotNil(t, err)
+}
+
+func TestParseArray(t *testing.T) {
+	root, err := Parse(`[1, "a", b + 1, []]`)
+	assert.Nil(t, err)
+
+	array, ok := root.Root.(*ast.ArrayNode)
+	assert.True(t, ok)
+	assert.Equal(t, 4, len(array.Nodes))
+	assert.Equal(t, 1, array.Nodes[0].(*ast.IntNode).Value)
+	assert.Equal(t, "a", array.Nodes[1].(*ast.StringNode).Value)
+	assert.Equal(t, "+", array.Nodes[2].(*ast.BinaryNode).Operator)
+	assert.Equal(t, 0, len(array.Nodes[3].(*ast.ArrayNode).Nodes))
+
+	_, err = Parse("[1, 2")
+	assert.NotNil(t, err)
+}
+
+func TestParseMap(t *testing.T) {
+	root, err := Parse(`{"k": 1, k2: v2, 3: [], }`)
+	assert.Nil(t, err)
+
+	m, ok := root.Root.(*ast.MapNode)
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(m.Pairs))
+	assert.Equal(t, "k", m.Pairs[0].Key.(*ast.StringNode).Value)
+	assert.Equal(t, 1, m.Pairs[0].Value.(*ast.IntNode).Value)
+	assert.Equal(t, "k2", m.Pairs[1].Key.(*ast.StringNode).Value)
+	assert.Equal(t, "v2", m.Pairs[1].Value.(*ast.IdentifierNode).Value)
+	assert.Equal(t, 3, m.Pairs[2].Key.(*ast.IntNode).Value)
+
+	_, err = Parse(`{"k" 1}`)
+	assert.NotNil(t, err)
+}
+
+func TestParseArguments(t *testing.T) {
+	root, err := Parse("f(a, 1, g(b, c))")
+	assert.Nil(t, err)
+
+	fn, ok := root.Root.(*ast.FunctionNode)
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(fn.Arguments))
+	assert.Equal(t, 2, len(fn.Arguments[2].(*ast.FunctionNode).Arguments))
+}
+
+func TestParseNotIn(t *testing.T) {
+	root, err := Parse(`region not in ["north", "east"] and not flag`)
+	assert.Nil(t, err)
+
+	and, ok := root.Root.(*ast.BinaryNode)
+	assert.True(t, ok)
+	assert.Equal(t, "and", and.Operator)
+	assert.Equal(t, "not in", and.Left.(*ast.BinaryNode).Operator)
+	assert.Equal(t, "not", and.Right.(*ast.UnaryNode).Operator)
+}
+
+func TestParseIndexSlice(t *testing.T) {
+	root, err := Parse(`m["k"].a[-1]`)
+	assert.Nil(t, err)
+
+	index, ok := root.Root.(*ast.IndexNode)
+	assert.True(t, ok)
+	assert.Equal(t, "-", index.Index.(*ast.UnaryNode).Operator)
+	property, ok := index.Node.(*ast.PropertyNode)
+	assert.True(t, ok)
+	assert.Equal(t, "a", property.Property)
+	assert.Equal(t, "k", property.Node.(*ast.IndexNode).Index.(*ast.StringNode).Value)
+
+	cases := []struct {
+		source string
+		from   bool
+		to     bool
+	}{
+		{"x[1:2]", true, true},
+		{"x[1:]", true, false},
+		{"x[:2]", false, true},
+		{"x[:]", false, false},
+		{"'abc'[1:]", true, false},
+	}
+	for _, c := range cases {
+		root, err := Parse(c.source)
+		assert.Nil(t, err)
+
+		slice, ok := root.Root.(*ast.SliceNode)
+		assert.True(t, ok, c.source)
+		assert.Equal(t, c.from, slice.From != nil, c.source)
+		assert.Equal(t, c.to, slice.To != nil, c.source)
+	}
+
+	root, err = Parse(`"abc"[0]`)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", root.Root.(*ast.IndexNode).Node.(*ast.StringNode).Value)
+
+	_, err = Parse("x[1")
+	assert.NotNil(t, err)
+}
+
+func TestParsePower(t *testing.T) {
+	root, err := Parse("2 ^ 3 ^ 2 % 5")
+	assert.Nil(t, err)
+
+	mod, ok := root.Root.(*ast.BinaryNode)
+	assert.True(t, ok)
+	assert.Equal(t, "%", mod.Operator)
+
+	pow := mod.Left.(*ast.BinaryNode)
+	assert.Equal(t, "^", pow.Operator)
+	assert.Equal(t, 2, pow.Left.(*ast.IntNode).Value)
+	assert.Equal(t, "^", pow.Right.(*ast.BinaryNode).Operator)
+}
+
+func TestParsePosition(t *testing.T) {
+	root, err := Parse("a +\n  foo(b).x")
+	assert.Nil(t, err)
+
+	binary := root.Root.(*ast.BinaryNode)
+	assert.Equal(t, Position{Line: 1, Offset: 0}, binary.Start())
+	assert.Equal(t, Position{Line: 2, Offset: 10}, binary.End())
+
+	property := binary.Right.(*ast.PropertyNode)
+	assert.Equal(t, Position{Line: 2, Offset: 2}, property.Start())
+	assert.Equal(t, Position{Line: 2, Offset: 10}, property.End())
+
+	fn := property.Node.(*ast.FunctionNode)
+	assert.Equal(t, Position{Line: 2, Offset: 2}, fn.Start())
+	assert.Equal(t, Position{Line: 2, Offset: 8}, fn.End())
+
+	arg := fn.Arguments[0]
+	assert.Equal(t, Position{Line: 2, Offset: 6}, arg.Start())
+	assert.Equal(t, Position{Line: 2, Offset: 7}, arg.End())
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	_, err := Parse("1 + )")
+	assert.EqualError(t, err, "1:5: unexpected token )\n | 1 + )\n |     ^")
+
+	_, err = Parse("x[1 2]")
+	assert.EqualError(t, err, "1:5: expect ]\n | x[1 2]\n |     ^")
+
+	_, err = Parse("a +\n 'b")
+	assert.EqualError(t, err, "2:2: unexpected terminated\n |  'b\n |  ^~")
+}
+
+func TestParseErrorList(t *testing.T) {
+	tree, err := Parse("f([1 2], g(, 3), {a: 1 b: 2}, (x + 1)")
+	assert.NotNil(t, tree)
+
+	errors, ok := err.(ErrorList)
+	assert.True(t, ok)
+	assert.Len(t, errors, 4)
+	assert.Equal(t, "expect , or ]", errors[0].Message)
+	assert.Equal(t, "unexpected token ,", errors[1].Message)
+	assert.Equal(t, "expect , or }", errors[2].Message)
+	assert.Equal(t, "expect )", errors[3].Message)
+
+	_, err = Parse("a ? b")
+	assert.EqualError(t, err, "1:6: expect :\n | a ? b\n |      ^")
+
+	_, err = Parse("a # b @ c")
+	assert.Len(t, err, 3)
+}
+
+func TestParseNeverNil(t *testing.T) {
+	sources := []string{"", "(", ")", "[", "{", "a.", "a[", "a[:", "f(1,", "{1}", "-", "a ? ", "not in", "x[1", "(1]"}
+	for _, source := range sources {
+		tree, err := Parse(source)
+		assert.NotNil(t, err, source)
+		walk(t, tree.Root, source)
+	}
+}
+
+func walk(t *testing.T, node ast.Node, source string) {
+	if !assert.NotNil(t, node, source) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *ast.UnaryNode:
+		walk(t, n.Expr, source)
+	case *ast.BinaryNode:
+		walk(t, n.Left, source)
+		walk(t, n.Right, source)
+	case *ast.ConditionalNode:
+		walk(t, n.Cond, source)
+		walk(t, n.Then, source)
+		walk(t, n.Else, source)
+	case *ast.MethodNode:
+		walk(t, n.Node, source)
+		for _, arg := range n.Arguments {
+			walk(t, arg, source)
+		}
+	case *ast.FunctionNode:
+		for _, arg := range n.Arguments {
+			walk(t, arg, source)
+		}
+	case *ast.PropertyNode:
+		walk(t, n.Node, source)
+	case *ast.IndexNode:
+		walk(t, n.Node, source)
+		walk(t, n.Index, source)
+	case *ast.SliceNode:
+		walk(t, n.Node, source)
+	case *ast.ArrayNode:
+		for _, item := range n.Nodes {
+			walk(t, item, source)
+		}
+	case *ast.MapNode:
+		for _, pair := range n.Pairs {
+			walk(t, pair.Key, source)
+			walk(t, pair.Value, source)
+		}
+	}
+}
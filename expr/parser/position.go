@@ -1,6 +1,5 @@
 package parser
 
-type Position struct {
-	Line   int
-	Offset int
-}
+import "github.com/gscienty/causer/expr/file"
+
+type Position = file.Position
@@ -13,6 +13,7 @@ const (
 
 type Token struct {
 	Position Position
+	End      Position
 	Kind     Kind
 	Value    string
 }
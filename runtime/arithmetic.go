@@ -0,0 +1,189 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+var kindTypes = map[reflect.Kind]reflect.Type{
+	reflect.Int:     reflect.TypeOf(int(0)),
+	reflect.Int8:    reflect.TypeOf(int8(0)),
+	reflect.Int16:   reflect.TypeOf(int16(0)),
+	reflect.Int32:   reflect.TypeOf(int32(0)),
+	reflect.Int64:   reflect.TypeOf(int64(0)),
+	reflect.Uint:    reflect.TypeOf(uint(0)),
+	reflect.Uint8:   reflect.TypeOf(uint8(0)),
+	reflect.Uint16:  reflect.TypeOf(uint16(0)),
+	reflect.Uint32:  reflect.TypeOf(uint32(0)),
+	reflect.Uint64:  reflect.TypeOf(uint64(0)),
+	reflect.Uintptr: reflect.TypeOf(uintptr(0)),
+	reflect.Float32: reflect.TypeOf(float32(0)),
+	reflect.Float64: reflect.TypeOf(float64(0)),
+}
+
+func Promote(left, right reflect.Type) reflect.Type {
+	if left == right {
+		return left
+	}
+
+	leftKind, rightKind := left.Kind(), right.Kind()
+	switch {
+	case isFloat(leftKind) || isFloat(rightKind):
+		if leftKind == reflect.Float32 && rightKind == reflect.Float32 {
+			return kindTypes[reflect.Float32]
+		}
+		return kindTypes[reflect.Float64]
+	case isUnsigned(leftKind) && isUnsigned(rightKind):
+		return wider(left, right, reflect.Uint64)
+	case isInteger(leftKind) && isInteger(rightKind):
+		return wider(left, right, reflect.Int64)
+	}
+	return kindTypes[reflect.Int64]
+}
+
+func wider(left, right reflect.Type, tie reflect.Kind) reflect.Type {
+	switch {
+	case left.Size() > right.Size():
+		return kindTypes[left.Kind()]
+	case left.Size() < right.Size():
+		return kindTypes[right.Kind()]
+	}
+	return kindTypes[tie]
+}
+
+func toInt64(v reflect.Value) (int64, error) {
+	if isUnsigned(v.Kind()) {
+		if v.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("integer overflow: %v (type %v) overflows int64", v.Uint(), v.Type())
+		}
+		return int64(v.Uint()), nil
+	}
+	return v.Int(), nil
+}
+
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	if isNil(left) || isNil(right) {
+		return nil, fmt.Errorf("invalid operation: %T %s %T", left, op, right)
+	}
+
+	leftValue, rightValue := reflect.ValueOf(left), reflect.ValueOf(right)
+
+	if op == runtimeOpAdd && leftValue.Kind() == reflect.String && rightValue.Kind() == reflect.String {
+		return leftValue.String() + rightValue.String(), nil
+	}
+	if !isNumber(leftValue.Kind()) || !isNumber(rightValue.Kind()) {
+		return nil, fmt.Errorf("invalid operation: %T %s %T", left, op, right)
+	}
+
+	resultType := Promote(leftValue.Type(), rightValue.Type())
+
+	var ret interface{}
+	var err error
+	switch {
+	case isFloat(resultType.Kind()):
+		ret, err = floatOp(op, toFloat64(leftValue), toFloat64(rightValue))
+	case isUnsigned(resultType.Kind()):
+		ret, err = uintOp(op, leftValue.Uint(), rightValue.Uint())
+	default:
+		var l, r int64
+		if l, err = toInt64(leftValue); err != nil {
+			return nil, err
+		}
+		if r, err = toInt64(rightValue); err != nil {
+			return nil, err
+		}
+		ret, err = intOp(op, l, r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ret.(float64); ok && !isFloat(resultType.Kind()) {
+		return ret, nil
+	}
+	return reflect.ValueOf(ret).Convert(resultType).Interface(), nil
+}
+
+func floatOp(op string, left, right float64) (interface{}, error) {
+	switch op {
+	case runtimeOpAdd:
+		return left + right, nil
+	case runtimeOpSub:
+		return left - right, nil
+	case runtimeOpMul:
+		return left * right, nil
+	case runtimeOpDiv:
+		return left / right, nil
+	case runtimeOpMod:
+		return math.Mod(left, right), nil
+	case runtimeOpPow:
+		return math.Pow(left, right), nil
+	}
+	return nil, fmt.Errorf("invalid operator %s", op)
+}
+
+func intOp(op string, left, right int64) (interface{}, error) {
+	switch op {
+	case runtimeOpAdd:
+		return left + right, nil
+	case runtimeOpSub:
+		return left - right, nil
+	case runtimeOpMul:
+		return left * right, nil
+	case runtimeOpDiv:
+		if right == 0 {
+			return nil, fmt.Errorf("integer divide by zero")
+		}
+		return left / right, nil
+	case runtimeOpMod:
+		if right == 0 {
+			return nil, fmt.Errorf("integer divide by zero")
+		}
+		return left % right, nil
+	case runtimeOpPow:
+		if right < 0 {
+			return math.Pow(float64(left), float64(right)), nil
+		}
+		ret := int64(1)
+		for ; right > 0; right >>= 1 {
+			if right&1 == 1 {
+				ret *= left
+			}
+			left *= left
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("invalid operator %s", op)
+}
+
+func uintOp(op string, left, right uint64) (interface{}, error) {
+	switch op {
+	case runtimeOpAdd:
+		return left + right, nil
+	case runtimeOpSub:
+		return left - right, nil
+	case runtimeOpMul:
+		return left * right, nil
+	case runtimeOpDiv:
+		if right == 0 {
+			return nil, fmt.Errorf("integer divide by zero")
+		}
+		return left / right, nil
+	case runtimeOpMod:
+		if right == 0 {
+			return nil, fmt.Errorf("integer divide by zero")
+		}
+		return left % right, nil
+	case runtimeOpPow:
+		ret := uint64(1)
+		for ; right > 0; right >>= 1 {
+			if right&1 == 1 {
+				ret *= left
+			}
+			left *= left
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("invalid operator %s", op)
+}
@@ -1,6 +1,136 @@
 package runtime
 
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
 type Call struct {
 	Name         string
 	ArgumentsCnt int
 }
+
+func MatchOperator(impl interface{}, left, right reflect.Type) (reflect.Type, bool) {
+	implType := reflect.TypeOf(impl)
+	if implType == nil || implType.Kind() != reflect.Func {
+		return nil, false
+	}
+	if implType.NumIn() != 2 || implType.NumOut() != 1 || implType.IsVariadic() {
+		return nil, false
+	}
+	if !left.AssignableTo(implType.In(0)) || !right.AssignableTo(implType.In(1)) {
+		return nil, false
+	}
+	return implType.Out(0), true
+}
+
+type ArgumentError struct {
+	Function string
+	Index    int
+	Value    interface{}
+	Type     reflect.Type
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("cannot use %v (type %T) as %v in argument %d to %s", e.Value, e.Value, e.Type, e.Index+1, e.Function)
+}
+
+func adaptArguments(name string, fnType reflect.Type, in []reflect.Value) ([]reflect.Value, error) {
+	numIn := fnType.NumIn()
+	if fnType.IsVariadic() {
+		if len(in) < numIn-1 {
+			return nil, fmt.Errorf("wrong number of arguments for %s: expect at least %d, got %d", name, numIn-1, len(in))
+		}
+	} else if len(in) != numIn {
+		return nil, fmt.Errorf("wrong number of arguments for %s: expect %d, got %d", name, numIn, len(in))
+	}
+
+	out := make([]reflect.Value, len(in))
+	for i, arg := range in {
+		var t reflect.Type
+		if fnType.IsVariadic() && i >= numIn-1 {
+			t = fnType.In(numIn - 1).Elem()
+		} else {
+			t = fnType.In(i)
+		}
+
+		v, ok := convertArgument(arg, t)
+		if !ok {
+			var value interface{}
+			if arg.IsValid() && arg.CanInterface() {
+				value = arg.Interface()
+			}
+			return nil, &ArgumentError{Function: name, Index: i, Value: value, Type: t}
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func convertArgument(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	if v.IsValid() && v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Kind() == reflect.Interface {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), true
+		}
+		return v, false
+	}
+
+	if v.Type().AssignableTo(t) {
+		return v, true
+	}
+
+	from, to := v.Kind(), t.Kind()
+	switch {
+	case !isNumber(from) || !isNumber(to):
+		return v, false
+	case isFloat(to):
+		f := toFloat64(v)
+		if reflect.Zero(t).OverflowFloat(f) {
+			return v, false
+		}
+		return reflect.ValueOf(f).Convert(t), true
+	case isFloat(from):
+		f := v.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxUint64 {
+			return v, false
+		}
+		if f >= 0 {
+			return convertUint(uint64(f), t)
+		}
+		return convertInt(int64(f), t)
+	case isUnsigned(from):
+		return convertUint(v.Uint(), t)
+	}
+	return convertInt(v.Int(), t)
+}
+
+func convertInt(i int64, t reflect.Type) (reflect.Value, bool) {
+	if isUnsigned(t.Kind()) {
+		if i < 0 {
+			return reflect.Value{}, false
+		}
+		return convertUint(uint64(i), t)
+	}
+	if reflect.Zero(t).OverflowInt(i) {
+		return reflect.Value{}, false
+	}
+	return reflect.ValueOf(i).Convert(t), true
+}
+
+func convertUint(u uint64, t reflect.Type) (reflect.Value, bool) {
+	if isInteger(t.Kind()) {
+		if u > math.MaxInt64 {
+			return reflect.Value{}, false
+		}
+		return convertInt(int64(u), t)
+	}
+	if reflect.Zero(t).OverflowUint(u) {
+		return reflect.Value{}, false
+	}
+	return reflect.ValueOf(u).Convert(t), true
+}
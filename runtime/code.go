@@ -1,5 +1,11 @@
 package runtime
 
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
 const (
 	OpCodeAdd byte = iota
 	OpCodeSub
@@ -17,4 +23,216 @@ const (
 	OpCodeTrue
 	OpCodeFalse
 	OpCodeNil
+	OpCodeEqual
+	OpCodeNotEqual
+	OpCodeLess
+	OpCodeGreater
+	OpCodeLessEqual
+	OpCodeGreaterEqual
+	OpCodeJump
+	OpCodeJumpIfFalse
+	OpCodeJumpIfTrue
+	OpCodeArray
+	OpCodeMap
+	OpCodeIn
+	OpCodeIndex
+	OpCodeSlice
+	OpCodeMethod
+	OpCodeFetchPath
+	OpCodeAddConst
+	OpCodeCompareConst
+	OpCodeWide
+
+	opCodeEnd
 )
+
+const maxInt = int(^uint(0) >> 1)
+
+type OpCode byte
+
+var opCodeNames = [opCodeEnd]string{
+	OpCodeAdd:          "Add",
+	OpCodeSub:          "Sub",
+	OpCodeMul:          "Mul",
+	OpCodeDiv:          "Div",
+	OpCodePow:          "Pow",
+	OpCodeMod:          "Mod",
+	OpCodePop:          "Pop",
+	OpCodePush:         "Push",
+	OpCodeCall:         "Call",
+	OpCodeNot:          "Not",
+	OpCodeNegate:       "Negate",
+	OpCodeProperty:     "Property",
+	OpCodeFetch:        "Fetch",
+	OpCodeTrue:         "True",
+	OpCodeFalse:        "False",
+	OpCodeNil:          "Nil",
+	OpCodeEqual:        "Equal",
+	OpCodeNotEqual:     "NotEqual",
+	OpCodeLess:         "Less",
+	OpCodeGreater:      "Greater",
+	OpCodeLessEqual:    "LessEqual",
+	OpCodeGreaterEqual: "GreaterEqual",
+	OpCodeJump:         "Jump",
+	OpCodeJumpIfFalse:  "JumpIfFalse",
+	OpCodeJumpIfTrue:   "JumpIfTrue",
+	OpCodeArray:        "Array",
+	OpCodeMap:          "Map",
+	OpCodeIn:           "In",
+	OpCodeIndex:        "Index",
+	OpCodeSlice:        "Slice",
+	OpCodeMethod:       "Method",
+	OpCodeFetchPath:    "FetchPath",
+	OpCodeAddConst:     "AddConst",
+	OpCodeCompareConst: "CompareConst",
+	OpCodeWide:         "Wide",
+}
+
+func (op OpCode) String() string {
+	if byte(op) < opCodeEnd {
+		return opCodeNames[op]
+	}
+	return fmt.Sprintf("OpCode(%d)", byte(op))
+}
+
+type Operand byte
+
+const (
+	OperandConstant Operand = iota + 1
+	OperandCount
+	OperandJump
+	OperandOpCode
+)
+
+var operands = [opCodeEnd][]Operand{
+	OpCodePush:         {OperandConstant},
+	OpCodeCall:         {OperandConstant},
+	OpCodeProperty:     {OperandConstant},
+	OpCodeFetch:        {OperandConstant},
+	OpCodeJump:         {OperandJump},
+	OpCodeJumpIfFalse:  {OperandJump},
+	OpCodeJumpIfTrue:   {OperandJump},
+	OpCodeArray:        {OperandCount},
+	OpCodeMap:          {OperandCount},
+	OpCodeMethod:       {OperandConstant},
+	OpCodeFetchPath:    {OperandConstant},
+	OpCodeAddConst:     {OperandConstant},
+	OpCodeCompareConst: {OperandConstant, OperandOpCode},
+}
+
+func Operands(op byte) []Operand {
+	if op >= opCodeEnd {
+		return nil
+	}
+	return operands[op]
+}
+
+func (o Operand) width(wide bool) int {
+	switch {
+	case o == OperandOpCode:
+		return 1
+	case wide:
+		return 4
+	}
+	return 2
+}
+
+type Instruction struct {
+	OpCode   byte
+	Wide     bool
+	Operands []int
+	Offset   int
+	Width    int
+}
+
+func (i Instruction) IsJump() bool {
+	operands := Operands(i.OpCode)
+	return len(operands) == 1 && operands[0] == OperandJump
+}
+
+func (i Instruction) Target() int { return i.Offset + i.Width + i.Operands[0] }
+
+func Decode(code []byte, ip int) (Instruction, error) {
+	inst := Instruction{Offset: ip}
+	if ip < len(code) && code[ip] == OpCodeWide {
+		inst.Wide = true
+		ip++
+	}
+	if ip >= len(code) {
+		return inst, fmt.Errorf("%w: truncated instruction at %d", ErrInvalidProgram, inst.Offset)
+	}
+
+	inst.OpCode = code[ip]
+	if inst.OpCode >= opCodeEnd {
+		return inst, fmt.Errorf("%w: unknown opcode %d at %d", ErrInvalidProgram, inst.OpCode, ip)
+	}
+	if inst.Wide && !hasWideOperands(inst.OpCode) {
+		return inst, fmt.Errorf("%w: invalid wide prefix for %v at %d", ErrInvalidProgram, OpCode(inst.OpCode), inst.Offset)
+	}
+	ip++
+
+	inst.Operands = make([]int, 0, len(operands[inst.OpCode]))
+	for _, operand := range operands[inst.OpCode] {
+		width := operand.width(inst.Wide)
+		if ip+width > len(code) {
+			return inst, fmt.Errorf("%w: truncated instruction at %d", ErrInvalidProgram, inst.Offset)
+		}
+
+		switch width {
+		case 1:
+			inst.Operands = append(inst.Operands, int(code[ip]))
+		case 2:
+			inst.Operands = append(inst.Operands, int(binary.BigEndian.Uint16(code[ip:])))
+		case 4:
+			arg := binary.BigEndian.Uint32(code[ip:])
+			if uint64(arg) > uint64(maxInt) {
+				return inst, fmt.Errorf("%w: operand %d out of range at %d", ErrInvalidProgram, arg, inst.Offset)
+			}
+			inst.Operands = append(inst.Operands, int(arg))
+		}
+		ip += width
+	}
+
+	inst.Width = ip - inst.Offset
+	return inst, nil
+}
+
+func Encode(op byte, wide bool, args ...int) []byte {
+	code := make([]byte, 0, 1+1+4*len(args))
+	if wide {
+		code = append(code, OpCodeWide)
+	}
+	code = append(code, op)
+
+	for i, operand := range Operands(op) {
+		switch operand.width(wide) {
+		case 1:
+			code = append(code, byte(args[i]))
+		case 2:
+			code = append(code, 0, 0)
+			binary.BigEndian.PutUint16(code[len(code)-2:], uint16(args[i]))
+		case 4:
+			code = append(code, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(code[len(code)-4:], uint32(args[i]))
+		}
+	}
+	return code
+}
+
+func IsWide(args ...int) bool {
+	for _, arg := range args {
+		if arg > math.MaxUint16 {
+			return true
+		}
+	}
+	return false
+}
+
+func hasWideOperands(op byte) bool {
+	for _, operand := range Operands(op) {
+		if operand != OperandOpCode {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+		return value.IsNil()
+	}
+	return false
+}
+
+func Truthy(v interface{}) bool {
+	if isNil(v) {
+		return false
+	}
+
+	value := reflect.ValueOf(v)
+	switch {
+	case value.Kind() == reflect.Bool:
+		return value.Bool()
+	case isInteger(value.Kind()):
+		return value.Int() != 0
+	case isUnsigned(value.Kind()):
+		return value.Uint() != 0
+	case isFloat(value.Kind()):
+		return value.Float() != 0
+	}
+
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return value.Len() > 0
+	}
+	return true
+}
+
+func isNumber(kind reflect.Kind) bool {
+	return isInteger(kind) || isUnsigned(kind) || isFloat(kind)
+}
+
+func isInteger(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUnsigned(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func isFloat(kind reflect.Kind) bool {
+	return kind == reflect.Float32 || kind == reflect.Float64
+}
+
+func compareNumber(left, right reflect.Value) int {
+	switch {
+	case isInteger(left.Kind()) && isInteger(right.Kind()):
+		return compareInt64(left.Int(), right.Int())
+	case isUnsigned(left.Kind()) && isUnsigned(right.Kind()):
+		return compareUint64(left.Uint(), right.Uint())
+	case isInteger(left.Kind()) && isUnsigned(right.Kind()):
+		if left.Int() < 0 {
+			return -1
+		}
+		return compareUint64(uint64(left.Int()), right.Uint())
+	case isUnsigned(left.Kind()) && isInteger(right.Kind()):
+		if right.Int() < 0 {
+			return 1
+		}
+		return compareUint64(left.Uint(), uint64(right.Int()))
+	}
+
+	return compareFloat64(toFloat64(left), toFloat64(right))
+}
+
+func toFloat64(v reflect.Value) float64 {
+	switch {
+	case isInteger(v.Kind()):
+		return float64(v.Int())
+	case isUnsigned(v.Kind()):
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+func compareInt64(left, right int64) int {
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	}
+	return 0
+}
+
+func compareUint64(left, right uint64) int {
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(left, right float64) int {
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	}
+	return 0
+}
+
+func equal(left, right interface{}) bool {
+	if isNil(left) || isNil(right) {
+		return isNil(left) && isNil(right)
+	}
+
+	leftValue := reflect.ValueOf(left)
+	rightValue := reflect.ValueOf(right)
+
+	if isNumber(leftValue.Kind()) && isNumber(rightValue.Kind()) {
+		return compareNumber(leftValue, rightValue) == 0
+	}
+	if leftValue.Type() == timeType && rightValue.Type() == timeType {
+		return left.(time.Time).Equal(right.(time.Time))
+	}
+	if leftValue.Type() != rightValue.Type() {
+		return false
+	}
+	if isComparable(leftValue) && isComparable(rightValue) {
+		return left == right
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// isComparable reports whether == on the dynamic value cannot panic; a
+// comparable type may still hold a slice or map behind an interface.
+func isComparable(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Interface:
+		return value.IsNil() || isComparable(value.Elem())
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if !isComparable(value.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if !isComparable(value.Index(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return value.Type().Comparable()
+}
+
+func compare(left, right interface{}) (int, error) {
+	if isNil(left) || isNil(right) {
+		return 0, fmt.Errorf("invalid comparison between %T and %T", left, right)
+	}
+
+	leftValue := reflect.ValueOf(left)
+	rightValue := reflect.ValueOf(right)
+
+	switch {
+	case isNumber(leftValue.Kind()) && isNumber(rightValue.Kind()):
+		return compareNumber(leftValue, rightValue), nil
+	case leftValue.Kind() == reflect.String && rightValue.Kind() == reflect.String:
+		return strings.Compare(leftValue.String(), rightValue.String()), nil
+	case leftValue.Type() == timeType && rightValue.Type() == timeType:
+		leftTime, rightTime := left.(time.Time), right.(time.Time)
+		switch {
+		case leftTime.Before(rightTime):
+			return -1, nil
+		case leftTime.After(rightTime):
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	return 0, fmt.Errorf("invalid comparison between %T and %T", left, right)
+}
+
+func contains(container, element interface{}) (bool, error) {
+	if isNil(container) {
+		return false, nil
+	}
+
+	value := reflect.ValueOf(container)
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if equal(value.Index(i).Interface(), element) {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case reflect.Map:
+		key, ok := mapKey(value.Type().Key(), element)
+		if !ok {
+			return false, nil
+		}
+		return value.MapIndex(key).IsValid(), nil
+
+	case reflect.String:
+		s, ok := element.(string)
+		if !ok {
+			return false, fmt.Errorf("invalid operation %T in string", element)
+		}
+		return strings.Contains(value.String(), s), nil
+
+	case reflect.Struct:
+		name, ok := element.(string)
+		if !ok {
+			return false, fmt.Errorf("invalid operation %T in struct", element)
+		}
+		return value.FieldByName(name).IsValid(), nil
+	}
+
+	return false, fmt.Errorf("invalid operation in %T", container)
+}
+
+func mapKey(keyType reflect.Type, key interface{}) (reflect.Value, bool) {
+	if key == nil {
+		switch keyType.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			return reflect.Zero(keyType), true
+		}
+		return reflect.Value{}, false
+	}
+
+	value := reflect.ValueOf(key)
+	if !isComparable(value) {
+		return reflect.Value{}, false
+	}
+	if value.Type().AssignableTo(keyType) {
+		return value, true
+	}
+	if isNumber(value.Kind()) && isNumber(keyType.Kind()) {
+		converted := value.Convert(keyType)
+		if equal(converted.Interface(), key) {
+			return converted, true
+		}
+	}
+	return reflect.Value{}, false
+}
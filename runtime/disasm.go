@@ -0,0 +1,111 @@
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+func Disassemble(program *Program) string {
+	var out bytes.Buffer
+	w := tabwriter.NewWriter(&out, 0, 4, 2, ' ', 0)
+
+	lines := make([][]rune, 0)
+	for _, line := range strings.Split(program.source, "\n") {
+		lines = append(lines, []rune(line))
+	}
+	code := program.instructions
+	for ip := 0; ip < len(code); {
+		inst, err := Decode(code, ip)
+		if err != nil {
+			fmt.Fprintf(w, "%04d\t%v\t\t<%v>\t\n", ip, OpCode(code[ip]), err)
+			break
+		}
+
+		mnemonic := OpCode(inst.OpCode).String()
+		if inst.Wide {
+			mnemonic += ".wide"
+		}
+
+		args, comments := make([]string, 0), make([]string, 0)
+		for i, operand := range Operands(inst.OpCode) {
+			arg := inst.Operands[i]
+			switch operand {
+			case OperandConstant:
+				args = append(args, fmt.Sprint(arg))
+				if arg >= len(program.constants) {
+					comments = append(comments, "<invalid constant>")
+				} else if path, ok := program.constants[arg].([]interface{}); ok && inst.OpCode == OpCodeFetchPath {
+					comments = append(comments, formatPath(path))
+				} else {
+					comments = append(comments, formatConstant(program.constants[arg]))
+				}
+			case OperandCount:
+				args = append(args, fmt.Sprint(arg))
+			case OperandJump:
+				args = append(args, fmt.Sprint(arg))
+				comments = append(comments, fmt.Sprintf("-> %04d", inst.Target()))
+			case OperandOpCode:
+				comments = append(comments, OpCode(arg).String())
+			}
+		}
+
+		var source string
+		if location, ok := program.Location(ip); ok {
+			source = fmt.Sprintf("%v\t", location.Start)
+			if line := location.Start.Line - 1; line >= 0 && line < len(lines) {
+				source += fragment(lines[line], location)
+			}
+		}
+
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\t%s\n", ip, mnemonic, strings.Join(args, " "), strings.Join(comments, " "), source)
+		ip += inst.Width
+	}
+
+	w.Flush()
+	return out.String()
+}
+
+func formatConstant(constant interface{}) string {
+	switch v := constant.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case Call:
+		return fmt.Sprintf("%s/%d", v.Name, v.ArgumentsCnt)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatConstant(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[interface{}]interface{}:
+		pairs := make([]string, 0, len(v))
+		for key, value := range v {
+			pairs = append(pairs, formatConstant(key)+": "+formatConstant(value))
+		}
+		sort.Strings(pairs)
+		return "{" + strings.Join(pairs, ", ") + "}"
+	}
+	return fmt.Sprintf("%v", constant)
+}
+
+func formatPath(path []interface{}) string {
+	names := make([]string, len(path))
+	for i, name := range path {
+		names[i] = fmt.Sprint(name)
+	}
+	return strings.Join(names, ".")
+}
+
+func fragment(line []rune, location Location) string {
+	start, end := location.Start.Offset, len(line)
+	if location.End.Line == location.Start.Line && location.End.Offset < end {
+		end = location.End.Offset
+	}
+	if start < 0 || start > end {
+		return ""
+	}
+	return string(line[start:end])
+}
@@ -0,0 +1,50 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/gscienty/causer/expr/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpCodeString(t *testing.T) {
+	names := make(map[string]bool)
+	for op := byte(0); op < opCodeEnd; op++ {
+		name := OpCode(op).String()
+		assert.NotEmpty(t, name, "opcode %d has no name", op)
+		assert.False(t, names[name], "duplicate opcode name %s", name)
+		names[name] = true
+	}
+	assert.Equal(t, "JumpIfFalse", OpCode(OpCodeJumpIfFalse).String())
+	assert.Equal(t, "OpCode(255)", OpCode(255).String())
+}
+
+func TestDisassemble(t *testing.T) {
+	program := NewProgram([]byte{
+		OpCodeFetch, 0x00, 0x00,
+		OpCodeJumpIfFalse, 0x00, 0x07,
+		OpCodePop,
+		OpCodePush, 0x00, 0x01,
+		OpCodeCall, 0x00, 0x02,
+		OpCodeCompareConst, 0x00, 0x01, OpCodeLess,
+	}, []interface{}{"x", 1.5, Call{Name: "f", ArgumentsCnt: 1}},
+		WithSource("x and\n f(1.5) < 1.5"),
+		WithLocations([]Location{
+			{Offset: 0, Start: file.Position{Line: 1, Offset: 0}, End: file.Position{Line: 1, Offset: 1}},
+			{Offset: 3, Start: file.Position{Line: 1, Offset: 0}, End: file.Position{Line: 2, Offset: 13}},
+			{Offset: 10, Start: file.Position{Line: 2, Offset: 1}, End: file.Position{Line: 2, Offset: 7}},
+		}),
+	)
+
+	expect := "" +
+		"0000  Fetch         0  \"x\"       1:1  x\n" +
+		"0003  JumpIfFalse   7  -> 0013   1:1  x and\n" +
+		"0006  Pop                        1:1  x and\n" +
+		"0007  Push          1  1.5       1:1  x and\n" +
+		"0010  Call          2  f/1       2:2  f(1.5)\n" +
+		"0013  CompareConst  1  1.5 Less  2:2  f(1.5)\n"
+	assert.Equal(t, expect, Disassemble(program))
+
+	broken := program.With(WithLocations([]Location{{Offset: 0, Start: file.Position{Line: 1, Offset: -5}, End: file.Position{Line: 1, Offset: 1}}}))
+	assert.Contains(t, Disassemble(broken), "0000  Fetch")
+}
@@ -0,0 +1,54 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrStackUnderflow = errors.New("stack underflow")
+	ErrStackLeftover  = errors.New("values left on stack")
+)
+
+type Error struct {
+	OpCode             OpCode
+	InstructionPointer int
+	StackDepth         int
+	Line               int
+	Column             int
+	Snippet            string
+	Err                error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%v (opcode %v at %d, stack depth %d)", e.Err, e.OpCode, e.InstructionPointer, e.StackDepth)
+	if e.Line > 0 {
+		msg = fmt.Sprintf("%d:%d: %s", e.Line, e.Column, msg)
+	}
+	return msg + e.Snippet
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+type StepLimitError struct {
+	Limit int
+}
+
+func (e *StepLimitError) Error() string { return fmt.Sprintf("step limit %d exceeded", e.Limit) }
+
+type StackLimitError struct {
+	Limit int
+}
+
+func (e *StackLimitError) Error() string {
+	return fmt.Sprintf("stack depth limit %d exceeded", e.Limit)
+}
+
+type AllocationLimitError struct {
+	Limit int
+	Size  int
+}
+
+func (e *AllocationLimitError) Error() string {
+	return fmt.Sprintf("allocation of %d exceeds limit %d", e.Size, e.Limit)
+}
@@ -0,0 +1,424 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gscienty/causer/expr/file"
+)
+
+const (
+	programMagic   = "CAUS"
+	programVersion = 2
+)
+
+var ErrInvalidProgram = errors.New("invalid program")
+
+const (
+	tagNil byte = iota + 1
+	tagBool
+	tagInt
+	tagInt8
+	tagInt16
+	tagInt32
+	tagInt64
+	tagUint
+	tagUint8
+	tagUint16
+	tagUint32
+	tagUint64
+	tagFloat32
+	tagFloat64
+	tagString
+	tagCall
+	tagArray
+	tagMap
+)
+
+func (p *Program) MarshalBinary() ([]byte, error) {
+	e := &encoder{}
+	e.buf.WriteString(programMagic)
+	e.uvarint(programVersion)
+
+	e.uvarint(uint64(len(p.constants)))
+	for _, constant := range p.constants {
+		if err := e.constant(constant); err != nil {
+			return nil, err
+		}
+	}
+
+	e.bytes(p.instructions)
+
+	e.limit(p.limits.MaxSteps)
+	e.limit(p.limits.MaxStackDepth)
+	e.limit(p.limits.MaxAllocation)
+
+	if p.source == "" && len(p.locations) == 0 {
+		e.buf.WriteByte(0)
+	} else {
+		e.buf.WriteByte(1)
+		e.string(p.source)
+		e.uvarint(uint64(len(p.locations)))
+		for _, location := range p.locations {
+			e.uvarint(uint64(location.Offset))
+			e.position(location.Start)
+			e.position(location.End)
+		}
+	}
+
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, crc32.ChecksumIEEE(e.buf.Bytes()))
+	e.buf.Write(checksum)
+
+	return e.buf.Bytes(), nil
+}
+
+func (p *Program) UnmarshalBinary(data []byte) error {
+	if len(data) < len(programMagic)+4 || string(data[:len(programMagic)]) != programMagic {
+		return fmt.Errorf("%w: bad magic", ErrInvalidProgram)
+	}
+
+	body, checksum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(checksum) {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidProgram)
+	}
+
+	d := &decoder{data: body[len(programMagic):]}
+	if version := d.uvarint(); d.err == nil && version != programVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidProgram, version)
+	}
+
+	constants := make([]interface{}, d.length())
+	for i := range constants {
+		constants[i] = d.constant()
+	}
+
+	instructions := d.bytes()
+
+	limits := Limits{
+		MaxSteps:      d.limit(),
+		MaxStackDepth: d.limit(),
+		MaxAllocation: d.limit(),
+	}
+
+	var source string
+	var locations []Location
+	if flag := d.next(); flag == 1 {
+		source = d.string()
+		locations = make([]Location, d.length())
+		for i := range locations {
+			locations[i].Offset = int(d.uvarint())
+			locations[i].Start = d.position()
+			locations[i].End = d.position()
+		}
+	} else if flag != 0 {
+		d.fail("invalid source map flag %d", flag)
+	}
+
+	if d.err == nil && len(d.data) > 0 {
+		d.fail("trailing %d bytes", len(d.data))
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	if !sort.SliceIsSorted(locations, func(i, j int) bool { return locations[i].Offset < locations[j].Offset }) {
+		return fmt.Errorf("%w: unsorted source map", ErrInvalidProgram)
+	}
+	lines := strings.Split(source, "\n")
+	for i, location := range locations {
+		if !validLocation(lines, len(instructions), location) {
+			return fmt.Errorf("%w: invalid source map entry %d", ErrInvalidProgram, i)
+		}
+	}
+
+	program := NewProgram(instructions, constants, WithSource(source), WithLocations(locations), WithLimits(limits))
+	if err := Verify(program); err != nil {
+		return err
+	}
+
+	*p = *program
+	return nil
+}
+
+func validLocation(lines []string, size int, location Location) bool {
+	if location.Offset < 0 || location.Offset >= size {
+		return false
+	}
+	for _, pos := range []file.Position{location.Start, location.End} {
+		if pos.Line < 1 || pos.Line > len(lines) || pos.Offset < 0 || pos.Offset > utf8.RuneCountInString(lines[pos.Line-1]) {
+			return false
+		}
+	}
+	start, end := location.Start, location.End
+	return start.Line < end.Line || start.Line == end.Line && start.Offset <= end.Offset
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uvarint(v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	e.buf.Write(b[:binary.PutUvarint(b, v)])
+}
+
+func (e *encoder) varint(v int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	e.buf.Write(b[:binary.PutVarint(b, v)])
+}
+
+func (e *encoder) limit(v int) {
+	if v < 0 {
+		v = 0
+	}
+	e.uvarint(uint64(v))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) position(pos file.Position) {
+	e.uvarint(uint64(pos.Line))
+	e.uvarint(uint64(pos.Offset))
+}
+
+func (e *encoder) constant(constant interface{}) error {
+	switch v := constant.(type) {
+	case nil:
+		e.buf.WriteByte(tagNil)
+	case bool:
+		e.buf.WriteByte(tagBool)
+		if v {
+			e.buf.WriteByte(1)
+		} else {
+			e.buf.WriteByte(0)
+		}
+	case int:
+		e.buf.WriteByte(tagInt)
+		e.varint(int64(v))
+	case int8:
+		e.buf.WriteByte(tagInt8)
+		e.varint(int64(v))
+	case int16:
+		e.buf.WriteByte(tagInt16)
+		e.varint(int64(v))
+	case int32:
+		e.buf.WriteByte(tagInt32)
+		e.varint(int64(v))
+	case int64:
+		e.buf.WriteByte(tagInt64)
+		e.varint(v)
+	case uint:
+		e.buf.WriteByte(tagUint)
+		e.uvarint(uint64(v))
+	case uint8:
+		e.buf.WriteByte(tagUint8)
+		e.uvarint(uint64(v))
+	case uint16:
+		e.buf.WriteByte(tagUint16)
+		e.uvarint(uint64(v))
+	case uint32:
+		e.buf.WriteByte(tagUint32)
+		e.uvarint(uint64(v))
+	case uint64:
+		e.buf.WriteByte(tagUint64)
+		e.uvarint(v)
+	case float32:
+		e.buf.WriteByte(tagFloat32)
+		e.uvarint(uint64(math.Float32bits(v)))
+	case float64:
+		e.buf.WriteByte(tagFloat64)
+		e.uvarint(math.Float64bits(v))
+	case string:
+		e.buf.WriteByte(tagString)
+		e.string(v)
+	case Call:
+		e.buf.WriteByte(tagCall)
+		e.string(v.Name)
+		e.uvarint(uint64(v.ArgumentsCnt))
+	case []interface{}:
+		e.buf.WriteByte(tagArray)
+		e.uvarint(uint64(len(v)))
+		for _, item := range v {
+			if err := e.constant(item); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		e.buf.WriteByte(tagMap)
+		e.uvarint(uint64(len(v)))
+		entries := make([][2][]byte, 0, len(v))
+		for key, value := range v {
+			k, val := &encoder{}, &encoder{}
+			if err := k.constant(key); err != nil {
+				return err
+			}
+			if err := val.constant(value); err != nil {
+				return err
+			}
+			entries = append(entries, [2][]byte{k.buf.Bytes(), val.buf.Bytes()})
+		}
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i][0], entries[j][0]) < 0 })
+		for _, entry := range entries {
+			e.buf.Write(entry[0])
+			e.buf.Write(entry[1])
+		}
+	default:
+		return fmt.Errorf("cannot marshal constant of type %T", constant)
+	}
+	return nil
+}
+
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrInvalidProgram, fmt.Sprintf(format, args...))
+	}
+	d.data = nil
+}
+
+func (d *decoder) next() byte {
+	if len(d.data) < 1 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) length() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail("length %d exceeds data", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) limit() int {
+	n := d.uvarint()
+	if n > uint64(maxInt) {
+		d.fail("limit %d out of range", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.length()
+	b := append([]byte(nil), d.data[:n]...)
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	n := d.length()
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func (d *decoder) position() file.Position {
+	return file.Position{Line: int(d.uvarint()), Offset: int(d.uvarint())}
+}
+
+func (d *decoder) constant() interface{} {
+	switch tag := d.next(); tag {
+	case tagNil:
+		return nil
+	case tagBool:
+		return d.next() != 0
+	case tagInt:
+		return int(d.varint())
+	case tagInt8:
+		return int8(d.varint())
+	case tagInt16:
+		return int16(d.varint())
+	case tagInt32:
+		return int32(d.varint())
+	case tagInt64:
+		return d.varint()
+	case tagUint:
+		return uint(d.uvarint())
+	case tagUint8:
+		return uint8(d.uvarint())
+	case tagUint16:
+		return uint16(d.uvarint())
+	case tagUint32:
+		return uint32(d.uvarint())
+	case tagUint64:
+		return d.uvarint()
+	case tagFloat32:
+		return math.Float32frombits(uint32(d.uvarint()))
+	case tagFloat64:
+		return math.Float64frombits(d.uvarint())
+	case tagString:
+		return d.string()
+	case tagCall:
+		return Call{Name: d.string(), ArgumentsCnt: int(d.uvarint())}
+	case tagArray:
+		array := make([]interface{}, d.length())
+		for i := range array {
+			array[i] = d.constant()
+		}
+		return array
+	case tagMap:
+		n := d.length()
+		m := make(map[interface{}]interface{}, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			key := d.constant()
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				d.fail("invalid map key type %T", key)
+				return nil
+			}
+			m[key] = d.constant()
+		}
+		return m
+	default:
+		if d.err == nil {
+			d.fail("unknown constant tag %d", tag)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,137 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"github.com/gscienty/causer/expr/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgramMarshal(t *testing.T) {
+	constants := []interface{}{
+		"x", 2, 1.5, float32(0.25), int8(-3), uint64(1 << 63), true, nil,
+		Call{Name: "f", ArgumentsCnt: 2},
+		[]interface{}{"p", "Age"},
+		[]interface{}{1, "a", []interface{}{2.0}},
+		map[interface{}]interface{}{"k": 1, 2: "v"},
+	}
+	program := NewProgram([]byte{
+		OpCodeFetch, 0x00, 0x00,
+		OpCodePush, 0x00, 0x01,
+		OpCodeMul,
+	}, constants,
+		WithSource("x * 2"),
+		WithLocations([]Location{{Offset: 0, Start: file.Position{Line: 1, Offset: 0}, End: file.Position{Line: 1, Offset: 5}}}),
+		WithLimits(Limits{MaxSteps: 100, MaxStackDepth: 8, MaxAllocation: 1 << 20}),
+	)
+
+	data, err := program.MarshalBinary()
+	assert.Nil(t, err)
+
+	var loaded Program
+	assert.Nil(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, program.Instructions(), loaded.Instructions())
+	assert.Equal(t, constants, loaded.Constants())
+	assert.Equal(t, program.Source(), loaded.Source())
+	assert.Equal(t, program.Locations(), loaded.Locations())
+	assert.Equal(t, program.Limits(), loaded.Limits())
+
+	ret, err := Run(&loaded, map[string]int{"x": 21})
+	assert.Nil(t, err)
+	assert.Equal(t, 42, ret)
+
+	limited, err := program.With(WithLimits(Limits{MaxStackDepth: 1})).MarshalBinary()
+	assert.Nil(t, err)
+	assert.True(t, errors.As(loaded.UnmarshalBinary(limited), new(*StackLimitError)))
+
+	stripped, err := program.With(WithSource(""), WithLocations(nil)).MarshalBinary()
+	assert.Nil(t, err)
+	assert.Less(t, len(stripped), len(data))
+	assert.Nil(t, loaded.UnmarshalBinary(stripped))
+	assert.Equal(t, "", loaded.Source())
+	assert.Empty(t, loaded.Locations())
+
+	_, err = NewProgram(nil, []interface{}{struct{}{}}).MarshalBinary()
+	assert.EqualError(t, err, "cannot marshal constant of type struct {}")
+}
+
+func TestProgramMarshalDeterministic(t *testing.T) {
+	m := make(map[interface{}]interface{})
+	for i := 0; i < 64; i++ {
+		m[i] = []interface{}{i, fmt.Sprint(i)}
+		m[fmt.Sprint(i)] = i
+	}
+	program := NewProgram([]byte{OpCodePush, 0x00, 0x00}, []interface{}{m})
+
+	expect, err := program.MarshalBinary()
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		data, err := program.MarshalBinary()
+		assert.Nil(t, err)
+		assert.Equal(t, expect, data)
+	}
+}
+
+func TestProgramUnmarshalInvalid(t *testing.T) {
+	data, err := NewProgram([]byte{OpCodePush, 0x00, 0x00}, []interface{}{"s"}).MarshalBinary()
+	assert.Nil(t, err)
+
+	seal := func(body []byte) []byte {
+		checksum := make([]byte, 4)
+		binary.BigEndian.PutUint32(checksum, crc32.ChecksumIEEE(body))
+		return append(append([]byte(nil), body...), checksum...)
+	}
+	body := data[:len(data)-4]
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[7] ^= 0xff
+
+	old := append([]byte(nil), body...)
+	old[4] = 0
+
+	unknownTag := append([]byte(nil), body...)
+	unknownTag[6] = 0x7f
+
+	badFlag := append([]byte(nil), body...)
+	badFlag[len(badFlag)-1] = 2
+
+	cases := []struct {
+		data   []byte
+		expect string
+	}{
+		{nil, "invalid program: bad magic"},
+		{[]byte("JUNKJUNK"), "invalid program: bad magic"},
+		{corrupted, "invalid program: checksum mismatch"},
+		{seal(old), "invalid program: unsupported version 0"},
+		{seal(body[:len(body)-7]), "invalid program: length 3 exceeds data"},
+		{seal(unknownTag), "invalid program: unknown constant tag 127"},
+		{seal(append(append([]byte(nil), body...), 0x00)), "invalid program: trailing 1 bytes"},
+		{seal(badFlag), "invalid program: invalid source map flag 2"},
+	}
+
+	for _, c := range cases {
+		var program Program
+		err := program.UnmarshalBinary(c.data)
+		assert.EqualError(t, err, c.expect)
+		assert.True(t, errors.Is(err, ErrInvalidProgram))
+	}
+
+	locations := [][]Location{
+		{{Offset: 3, Start: file.Position{Line: 1, Offset: 0}, End: file.Position{Line: 1, Offset: 1}}},
+		{{Offset: 0, Start: file.Position{Line: 2, Offset: 0}, End: file.Position{Line: 2, Offset: 0}}},
+		{{Offset: 0, Start: file.Position{Line: 1, Offset: -5}, End: file.Position{Line: 1, Offset: 1}}},
+		{{Offset: 0, Start: file.Position{Line: 1, Offset: 0}, End: file.Position{Line: 1, Offset: 4}}},
+		{{Offset: 0, Start: file.Position{Line: 1, Offset: 2}, End: file.Position{Line: 1, Offset: 1}}},
+	}
+	for _, l := range locations {
+		data, err := NewProgram([]byte{OpCodePush, 0x00, 0x00}, []interface{}{"s"}, WithSource("'s'"), WithLocations(l)).MarshalBinary()
+		assert.Nil(t, err)
+
+		var program Program
+		assert.EqualError(t, program.UnmarshalBinary(data), "invalid program: invalid source map entry 0")
+	}
+}
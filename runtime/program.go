@@ -0,0 +1,138 @@
+package runtime
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/gscienty/causer/expr/file"
+)
+
+type Program struct {
+	instructions []byte
+	constants    []interface{}
+	source       string
+	locations    []Location
+	envType      reflect.Type
+	limits       Limits
+
+	operators map[string][]interface{}
+	functions map[string]reflect.Value
+	debug     func(Trace)
+	stats     ConstantStats
+}
+
+type Location struct {
+	Offset int
+	Start  file.Position
+	End    file.Position
+}
+
+type Limits struct {
+	MaxSteps      int
+	MaxStackDepth int
+	MaxAllocation int
+}
+
+type Trace struct {
+	Steps         int
+	StackDepth    int
+	MaxStackDepth int
+}
+
+type ConstantStats struct {
+	Constants   int
+	Strings     int
+	StringBytes int
+	Composites  int
+	Lookups     int
+	Hits        int
+}
+
+type Option func(p *Program)
+
+func NewProgram(instructions []byte, constants []interface{}, options ...Option) *Program {
+	p := &Program{
+		instructions: instructions,
+		constants:    constants,
+		operators:    make(map[string][]interface{}),
+		functions:    make(map[string]reflect.Value),
+	}
+
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+func (p *Program) With(options ...Option) *Program {
+	clone := *p
+	clone.operators = make(map[string][]interface{}, len(p.operators))
+	for name, impls := range p.operators {
+		clone.operators[name] = append([]interface{}(nil), impls...)
+	}
+	clone.functions = make(map[string]reflect.Value, len(p.functions))
+	for name, fn := range p.functions {
+		clone.functions[name] = fn
+	}
+
+	for _, option := range options {
+		option(&clone)
+	}
+	return &clone
+}
+
+func (p *Program) Instructions() []byte     { return p.instructions }
+func (p *Program) Constants() []interface{} { return p.constants }
+func (p *Program) Source() string           { return p.source }
+func (p *Program) Locations() []Location    { return p.locations }
+func (p *Program) EnvType() reflect.Type    { return p.envType }
+func (p *Program) Limits() Limits           { return p.limits }
+
+func (p *Program) ConstantStats() ConstantStats { return p.stats }
+
+func (p *Program) Location(ip int) (Location, bool) {
+	i := sort.Search(len(p.locations), func(i int) bool { return p.locations[i].Offset > ip })
+	if i == 0 {
+		return Location{}, false
+	}
+	return p.locations[i-1], true
+}
+
+func WithInstructions(instructions []byte, constants []interface{}) Option {
+	return func(p *Program) {
+		p.instructions = instructions
+		p.constants = constants
+	}
+}
+
+func WithSource(source string) Option {
+	return func(p *Program) { p.source = source }
+}
+
+func WithLocations(locations []Location) Option {
+	return func(p *Program) { p.locations = locations }
+}
+
+func WithOperator(name string, fn interface{}) Option {
+	return func(p *Program) { p.operators[name] = append(p.operators[name], fn) }
+}
+
+func WithFunction(name string, fn interface{}) Option {
+	return func(p *Program) { p.functions[name] = reflect.ValueOf(fn) }
+}
+
+func WithEnvType(envType reflect.Type) Option {
+	return func(p *Program) { p.envType = envType }
+}
+
+func WithDebug(fn func(Trace)) Option {
+	return func(p *Program) { p.debug = fn }
+}
+
+func WithLimits(limits Limits) Option {
+	return func(p *Program) { p.limits = limits }
+}
+
+func WithConstantStats(stats ConstantStats) Option {
+	return func(p *Program) { p.stats = stats }
+}
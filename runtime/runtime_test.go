@@ -1,8 +1,11 @@
 package runtime
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -31,3 +34,505 @@ func TestRuntimeAdd(t *testing.T) {
 
 	fmt.Printf("%v", ret)
 }
+
+func TestRuntimeCompare(t *testing.T) {
+	type box struct{ V interface{} }
+	now := time.Now()
+
+	cases := []struct {
+		op     byte
+		left   interface{}
+		right  interface{}
+		expect bool
+	}{
+		{OpCodeEqual, 1, 1.0, true},
+		{OpCodeEqual, int8(-1), uint(1), false},
+		{OpCodeEqual, "a", "a", true},
+		{OpCodeEqual, true, false, false},
+		{OpCodeEqual, nil, nil, true},
+		{OpCodeEqual, (*int)(nil), nil, true},
+		{OpCodeEqual, 1, "1", false},
+		{OpCodeNotEqual, 1, nil, true},
+		{OpCodeNotEqual, now, now.UTC(), false},
+		{OpCodeEqual, box{[]int{1}}, box{[]int{1}}, true},
+		{OpCodeEqual, [1]interface{}{map[string]int{}}, [1]interface{}{1}, false},
+		{OpCodeNotEqual, box{[]int{1}}, box{[]int{2}}, true},
+		{OpCodeLess, 1, 1.5, true},
+		{OpCodeLess, int64(-1), uint64(0), true},
+		{OpCodeLess, "abc", "abd", true},
+		{OpCodeLess, now, now.Add(time.Second), true},
+		{OpCodeGreater, uint8(3), 2, true},
+		{OpCodeGreater, now, now.Add(time.Second), false},
+		{OpCodeLessEqual, 2, 2.0, true},
+		{OpCodeGreaterEqual, "b", "a", true},
+		{OpCodeGreaterEqual, 65, 65, true},
+	}
+
+	for _, c := range cases {
+		r := New([]byte{
+			OpCodePush, 0x00, 0x00,
+			OpCodePush, 0x00, 0x01,
+			c.op,
+		}, []interface{}{c.left, c.right}, nil)
+
+		ret, err := r.Run()
+		assert.Nil(t, err)
+		assert.Equal(t, c.expect, ret, "%v %d %v", c.left, c.op, c.right)
+	}
+}
+
+func TestRuntimeCompareRegister(t *testing.T) {
+	r := New([]byte{
+		OpCodePush, 0x00, 0x00,
+		OpCodePush, 0x00, 0x01,
+		OpCodeLess,
+	}, []interface{}{"10", 9}, nil)
+
+	r.Register("<", func(left string, right int) bool { return len(left) < right })
+
+	ret, err := r.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, true, ret)
+}
+
+func TestRuntimeIn(t *testing.T) {
+	type unit struct{ Region string }
+	type box struct{ V interface{} }
+
+	cases := []struct {
+		element   interface{}
+		container interface{}
+		expect    bool
+	}{
+		{"north", []string{"north", "east"}, true},
+		{"west", []interface{}{"north", "east"}, false},
+		{2, [3]float64{1, 2, 3}, true},
+		{"k", map[string]int{"k": 1}, true},
+		{"v", map[string]int{"k": 1}, false},
+		{1, map[int64]string{1: "a"}, true},
+		{1.5, map[int64]string{1: "a"}, false},
+		{nil, map[interface{}]bool{nil: true}, true},
+		{"ort", "north", true},
+		{"Region", unit{}, true},
+		{"Score", &unit{}, false},
+		{1, nil, false},
+		{box{[]int{1}}, []box{{1}, {[]int{1}}}, true},
+		{box{[]int{1}}, map[interface{}]bool{box{1}: true}, false},
+	}
+
+	for _, c := range cases {
+		r := New([]byte{
+			OpCodePush, 0x00, 0x00,
+			OpCodePush, 0x00, 0x01,
+			OpCodeIn,
+		}, []interface{}{c.element, c.container}, nil)
+
+		ret, err := r.Run()
+		assert.Nil(t, err)
+		assert.Equal(t, c.expect, ret, "%v in %v", c.element, c.container)
+	}
+}
+
+func TestRuntimeIndex(t *testing.T) {
+	vm := NewVM()
+
+	v, err := vm.fetch([]int{1, 2, 3}, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, v)
+
+	v, err = vm.fetch("abc", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", v)
+
+	v, err = vm.fetch(map[int64]string{2: "b"}, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", v)
+
+	_, err = vm.fetch([]int{1, 2, 3}, 3)
+	assert.EqualError(t, err, "index out of range [3] with length 3")
+
+	_, err = vm.fetch([2]int{1, 2}, -3)
+	assert.EqualError(t, err, "index out of range [-3] with length 2")
+
+	v, err = vm.fetch([]int{1, 2, 3}, uint8(2))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, v)
+
+	v, err = vm.fetch("héllo", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "é", v)
+
+	v, err = vm.fetch("héllo", -1)
+	assert.Nil(t, err)
+	assert.Equal(t, "o", v)
+
+	_, err = vm.fetch([]int{1, 2, 3}, "1")
+	assert.EqualError(t, err, "invalid index 1 of type string")
+
+	_, err = vm.fetch("abc", 1.5)
+	assert.EqualError(t, err, "invalid index 1.5 of type float64")
+
+	_, err = vm.fetch(map[string]int{}, []int{})
+	assert.NotNil(t, err)
+}
+
+func TestRuntimeSlice(t *testing.T) {
+	vm := NewVM()
+
+	v, err := vm.slice([]int{1, 2, 3}, 1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 3}, v)
+
+	v, err = vm.slice([3]int{1, 2, 3}, nil, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, v)
+
+	v, err = vm.slice("treated", 0, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "treat", v)
+
+	_, err = vm.slice([]int{1, 2, 3}, 2, 1)
+	assert.EqualError(t, err, "slice bounds out of range [2:1] with length 3")
+
+	v, err = vm.slice("héllo", 1, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "él", v)
+
+	v, err = vm.slice("", nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "", v)
+
+	_, err = vm.slice([]int{1, 2, 3}, 1.5, nil)
+	assert.EqualError(t, err, "invalid slice index 1.5 of type float64")
+
+	_, err = vm.slice("treated", nil, "2")
+	assert.EqualError(t, err, "invalid slice index 2 of type string")
+
+	_, err = vm.slice(1, nil, nil)
+	assert.NotNil(t, err)
+}
+
+func TestRuntimeError(t *testing.T) {
+	r := New([]byte{
+		OpCodePush, 0x00, 0x00,
+		OpCodePush, 0x00, 0x01,
+		OpCodeAdd,
+	}, []interface{}{1, "a"}, nil)
+
+	_, err := r.Run()
+	var rtErr *Error
+	assert.True(t, errors.As(err, &rtErr))
+	assert.Equal(t, OpCode(OpCodeAdd), rtErr.OpCode)
+	assert.Equal(t, 6, rtErr.InstructionPointer)
+	assert.Equal(t, 0, rtErr.StackDepth)
+	assert.EqualError(t, rtErr.Err, "invalid operation: int + string")
+
+	r = New([]byte{
+		OpCodeFetch, 0x00, 0x00,
+		OpCodePush, 0x00, 0x01,
+		OpCodeIndex,
+	}, []interface{}{"arms", 5}, map[string]interface{}{"arms": []int{1}})
+
+	_, err = r.Run()
+	assert.True(t, errors.As(err, &rtErr))
+	assert.Equal(t, OpCode(OpCodeIndex), rtErr.OpCode)
+	assert.EqualError(t, rtErr.Err, "index out of range [5] with length 1")
+
+	type unit struct{ Arm string }
+	arms := []int{1, 2}
+	cases := []struct {
+		instance interface{}
+		index    interface{}
+		expect   interface{}
+		err      string
+	}{
+		{1, 0, nil, "cannot index int"},
+		{true, 0, nil, "cannot index bool"},
+		{unit{Arm: "a"}, 0, nil, "invalid index 0 of type int"},
+		{unit{Arm: "a"}, "Arm", "a", ""},
+		{&unit{Arm: "a"}, "Arm", "a", ""},
+		{&arms, 1, 2, ""},
+		{(*unit)(nil), "Arm", nil, ""},
+		{nil, 0, nil, ""},
+	}
+	for _, c := range cases {
+		ret, err := New([]byte{
+			OpCodePush, 0x00, 0x00,
+			OpCodePush, 0x00, 0x01,
+			OpCodeIndex,
+		}, []interface{}{c.instance, c.index}, nil).Run()
+		if c.err != "" {
+			assert.EqualError(t, errors.Unwrap(err), c.err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, c.expect, ret)
+	}
+}
+
+func TestRuntimeCallError(t *testing.T) {
+	env := map[string]interface{}{
+		"half": func(a float64) float64 { return a / 2 },
+		"fail": func() (int, error) { return 0, fmt.Errorf("failed") },
+	}
+
+	cases := []struct {
+		call   Call
+		params []interface{}
+		expect string
+	}{
+		{Call{Name: "missing", ArgumentsCnt: 0}, nil, "unknown function missing"},
+		{Call{Name: "fail", ArgumentsCnt: 0}, nil, "failed"},
+		{Call{Name: "half", ArgumentsCnt: 1}, []interface{}{"x"}, "cannot use x (type string) as float64 in argument 1 to half"},
+		{Call{Name: "half", ArgumentsCnt: 2}, []interface{}{1, 2}, "wrong number of arguments for half: expect 1, got 2"},
+	}
+
+	for _, c := range cases {
+		instructions := make([]byte, 0)
+		constants := []interface{}{c.call}
+		for _, param := range c.params {
+			constants = append(constants, param)
+			instructions = append(instructions, OpCodePush, 0x00, byte(len(constants)-1))
+		}
+		instructions = append(instructions, OpCodeCall, 0x00, 0x00)
+
+		_, err := New(instructions, constants, env).Run()
+		var rtErr *Error
+		assert.True(t, errors.As(err, &rtErr))
+		assert.Equal(t, OpCode(OpCodeCall), rtErr.OpCode)
+		if c.expect != "" {
+			assert.EqualError(t, rtErr.Err, c.expect)
+		}
+	}
+}
+
+func TestRuntimeCallAdapter(t *testing.T) {
+	type celsius float64
+	env := map[string]interface{}{
+		"mul":   func(a float64, b int) float64 { return a * float64(b) },
+		"small": func(a int8, b uint) int { return int(a) + int(b) },
+		"temp":  func(c celsius) celsius { return c },
+		"sum": func(base float64, xs ...int) float64 {
+			for _, x := range xs {
+				base += float64(x)
+			}
+			return base
+		},
+		"join":   func(xs ...string) int { return len(xs) },
+		"isNil":  func(x *int, xs []int) bool { return x == nil && xs == nil },
+		"anyArg": func(x interface{}) interface{} { return x },
+	}
+
+	cases := []struct {
+		call   Call
+		params []interface{}
+		expect interface{}
+		err    string
+	}{
+		{Call{Name: "mul", ArgumentsCnt: 2}, []interface{}{2, 3}, 6.0, ""},
+		{Call{Name: "mul", ArgumentsCnt: 2}, []interface{}{uint8(2), 3.0}, 6.0, ""},
+		{Call{Name: "mul", ArgumentsCnt: 2}, []interface{}{2, 3.5}, nil, "cannot use 3.5 (type float64) as int in argument 2 to mul"},
+		{Call{Name: "mul", ArgumentsCnt: 2}, []interface{}{2, "3"}, nil, "cannot use 3 (type string) as int in argument 2 to mul"},
+		{Call{Name: "mul", ArgumentsCnt: 1}, []interface{}{2}, nil, "wrong number of arguments for mul: expect 2, got 1"},
+		{Call{Name: "small", ArgumentsCnt: 2}, []interface{}{int64(-3), 4}, 1, ""},
+		{Call{Name: "small", ArgumentsCnt: 2}, []interface{}{300, 4}, nil, "cannot use 300 (type int) as int8 in argument 1 to small"},
+		{Call{Name: "small", ArgumentsCnt: 2}, []interface{}{1, -4}, nil, "cannot use -4 (type int) as uint in argument 2 to small"},
+		{Call{Name: "temp", ArgumentsCnt: 1}, []interface{}{20}, celsius(20), ""},
+		{Call{Name: "sum", ArgumentsCnt: 1}, []interface{}{1}, 1.0, ""},
+		{Call{Name: "sum", ArgumentsCnt: 4}, []interface{}{1, 2, uint(3), 4.0}, 10.0, ""},
+		{Call{Name: "sum", ArgumentsCnt: 3}, []interface{}{1, 2, 0.5}, nil, "cannot use 0.5 (type float64) as int in argument 3 to sum"},
+		{Call{Name: "sum", ArgumentsCnt: 0}, nil, nil, "wrong number of arguments for sum: expect at least 1, got 0"},
+		{Call{Name: "join", ArgumentsCnt: 0}, nil, 0, ""},
+		{Call{Name: "isNil", ArgumentsCnt: 2}, []interface{}{nil, nil}, true, ""},
+		{Call{Name: "mul", ArgumentsCnt: 2}, []interface{}{nil, 1}, nil, "cannot use <nil> (type <nil>) as float64 in argument 1 to mul"},
+		{Call{Name: "anyArg", ArgumentsCnt: 1}, []interface{}{nil}, nil, ""},
+	}
+
+	for _, c := range cases {
+		instructions := make([]byte, 0)
+		constants := []interface{}{c.call}
+		for _, param := range c.params {
+			if param == nil {
+				instructions = append(instructions, OpCodeNil)
+				continue
+			}
+			constants = append(constants, param)
+			instructions = append(instructions, OpCodePush, 0x00, byte(len(constants)-1))
+		}
+		instructions = append(instructions, OpCodeCall, 0x00, 0x00)
+
+		ret, err := New(instructions, constants, env).Run()
+		if c.err != "" {
+			var argErr *ArgumentError
+			assert.EqualError(t, errors.Unwrap(err), c.err)
+			assert.Equal(t, strings.HasPrefix(c.err, "cannot use"), errors.As(err, &argErr))
+			continue
+		}
+		assert.Nil(t, err, c.call.Name)
+		assert.Equal(t, c.expect, ret, c.call.Name)
+	}
+}
+
+func TestRuntimeArithmetic(t *testing.T) {
+	cases := []struct {
+		op     byte
+		left   interface{}
+		right  interface{}
+		expect interface{}
+	}{
+		{OpCodeAdd, 1, 2, 3},
+		{OpCodeAdd, 1, 2.5, 3.5},
+		{OpCodeAdd, int8(1), 2, 3},
+		{OpCodeAdd, int64(1), 2, int64(3)},
+		{OpCodeAdd, int8(1), int16(2), int16(3)},
+		{OpCodeAdd, uint8(1), uint32(2), uint32(3)},
+		{OpCodeAdd, uint(1), -2, int64(-1)},
+		{OpCodeAdd, float32(1), float32(0.5), float32(1.5)},
+		{OpCodeAdd, float32(1), 0.5, 1.5},
+		{OpCodeAdd, "treat", "ed", "treated"},
+		{OpCodeAdd, time.Second, time.Second, 2 * time.Second},
+		{OpCodeSub, 1, 3, -2},
+		{OpCodeMul, 3, 1.5, 4.5},
+		{OpCodeDiv, 7, 2, 3},
+		{OpCodeDiv, 7, 2.0, 3.5},
+		{OpCodeDiv, -7, 2, -3},
+		{OpCodeMod, 7, 3, 1},
+		{OpCodeMod, 7.5, 2, 1.5},
+		{OpCodeMod, uint(7), uint(4), uint(3)},
+		{OpCodePow, 2, 10, 1024},
+		{OpCodePow, 2, -1, 0.5},
+		{OpCodePow, 4, 0.5, 2.0},
+	}
+
+	for _, c := range cases {
+		r := New([]byte{
+			OpCodePush, 0x00, 0x00,
+			OpCodePush, 0x00, 0x01,
+			c.op,
+		}, []interface{}{c.left, c.right}, nil)
+
+		ret, err := r.Run()
+		assert.Nil(t, err)
+		assert.Equal(t, c.expect, ret, "%v %d %v", c.left, c.op, c.right)
+	}
+}
+
+func TestRuntimeArithmeticError(t *testing.T) {
+	cases := []struct {
+		op     byte
+		left   interface{}
+		right  interface{}
+		expect string
+	}{
+		{OpCodeDiv, 1, 0, "integer divide by zero"},
+		{OpCodeMod, uint(1), uint(0), "integer divide by zero"},
+		{OpCodeSub, "a", "b", "invalid operation: string - string"},
+		{OpCodeAdd, nil, 1, "invalid operation: <nil> + int"},
+		{OpCodeMul, true, 1, "invalid operation: bool * int"},
+		{OpCodeAdd, uint64(1 << 63), 1, "integer overflow: 9223372036854775808 (type uint64) overflows int64"},
+		{OpCodeSub, int8(-1), uint64(1<<63 + 1), "integer overflow: 9223372036854775809 (type uint64) overflows int64"},
+	}
+
+	for _, c := range cases {
+		r := New([]byte{
+			OpCodePush, 0x00, 0x00,
+			OpCodePush, 0x00, 0x01,
+			c.op,
+		}, []interface{}{c.left, c.right}, nil)
+
+		_, err := r.Run()
+		assert.EqualError(t, errors.Unwrap(err), c.expect)
+	}
+}
+
+func TestRuntimeArithmeticOverride(t *testing.T) {
+	type money struct{ Cents int }
+
+	r := New([]byte{
+		OpCodePush, 0x00, 0x00,
+		OpCodePush, 0x00, 0x01,
+		OpCodeAdd,
+		OpCodePush, 0x00, 0x02,
+		OpCodeMul,
+	}, []interface{}{money{100}, money{50}, 2}, nil)
+
+	r.Register("+", func(left, right money) money { return money{left.Cents + right.Cents} })
+	r.Register("*", func(left money, right int) money { return money{left.Cents * right} })
+
+	ret, err := r.Run()
+	assert.Nil(t, err)
+	assert.Equal(t, money{300}, ret)
+}
+
+func TestRuntimeOverrideExactType(t *testing.T) {
+	type celsius float64
+	type money struct{ Cents int }
+	now := time.Now()
+
+	cases := []struct {
+		op     byte
+		left   interface{}
+		right  interface{}
+		expect interface{}
+	}{
+		{OpCodeAdd, celsius(1), celsius(2), celsius(30)},
+		{OpCodeAdd, 1.5, 2.0, 3.5},
+		{OpCodeLess, money{1}, money{2}, true},
+		{OpCodeLess, now, now.Add(time.Second), true},
+	}
+
+	for _, c := range cases {
+		r := New([]byte{
+			OpCodePush, 0x00, 0x00,
+			OpCodePush, 0x00, 0x01,
+			c.op,
+		}, []interface{}{c.left, c.right}, nil)
+		r.Register("+", func(left, right celsius) celsius { return (left + right) * 10 })
+		r.Register("<", func(left, right money) bool { return left.Cents < right.Cents })
+
+		ret, err := r.Run()
+		assert.Nil(t, err)
+		assert.Equal(t, c.expect, ret, "%v %d %v", c.left, c.op, c.right)
+	}
+}
+
+func TestRuntimeInstructions(t *testing.T) {
+	vm := NewVM()
+	for op := byte(0); op < opCodeEnd; op++ {
+		assert.NotNil(t, vm.instFunc[op], "opcode %d has no handler", op)
+	}
+}
+
+func TestRuntimeUnary(t *testing.T) {
+	cases := []struct {
+		instructions []byte
+		constant     interface{}
+		expect       interface{}
+	}{
+		{[]byte{OpCodeTrue}, nil, true},
+		{[]byte{OpCodeFalse}, nil, false},
+		{[]byte{OpCodeNil}, nil, nil},
+		{[]byte{OpCodeTrue, OpCodeNot}, nil, false},
+		{[]byte{OpCodeNil, OpCodeNot}, nil, true},
+		{[]byte{OpCodePush, 0x00, 0x00, OpCodeNot}, 0, true},
+		{[]byte{OpCodePush, 0x00, 0x00, OpCodeNot}, "", true},
+		{[]byte{OpCodePush, 0x00, 0x00, OpCodeNot}, []int{1}, false},
+		{[]byte{OpCodePush, 0x00, 0x00, OpCodeNegate}, 3, -3},
+		{[]byte{OpCodePush, 0x00, 0x00, OpCodeNegate}, int8(3), int8(-3)},
+		{[]byte{OpCodePush, 0x00, 0x00, OpCodeNegate}, uint(3), int64(-3)},
+		{[]byte{OpCodePush, 0x00, 0x00, OpCodeNegate}, float32(1.5), float32(-1.5)},
+		{[]byte{OpCodePush, 0x00, 0x00, OpCodeNegate}, time.Second, -time.Second},
+	}
+
+	for _, c := range cases {
+		ret, err := New(c.instructions, []interface{}{c.constant}, nil).Run()
+		assert.Nil(t, err)
+		assert.Equal(t, c.expect, ret, "%v", c.instructions)
+	}
+
+	_, err := New([]byte{OpCodePush, 0x00, 0x00, OpCodeNegate}, []interface{}{"a"}, nil).Run()
+	assert.EqualError(t, errors.Unwrap(err), "invalid operation: -string")
+
+	_, err = New([]byte{OpCodePush, 0x00, 0x00, OpCodeNegate}, []interface{}{uint64(1 << 63)}, nil).Run()
+	assert.EqualError(t, errors.Unwrap(err), "integer overflow: 9223372036854775808 (type uint64) overflows int64")
+}
@@ -0,0 +1,210 @@
+package runtime
+
+import "fmt"
+
+func Verify(program *Program) error {
+	code := program.instructions
+	if len(code) == 0 {
+		return fmt.Errorf("%w: empty program", ErrInvalidProgram)
+	}
+
+	instructions := make([]Instruction, 0)
+	boundaries := make([]bool, len(code)+1)
+	for ip := 0; ip < len(code); {
+		inst, err := Decode(code, ip)
+		if err != nil {
+			return err
+		}
+		if err := verifyOperands(program, inst); err != nil {
+			return err
+		}
+
+		instructions = append(instructions, inst)
+		boundaries[ip] = true
+		ip += inst.Width
+	}
+	boundaries[len(code)] = true
+
+	for _, inst := range instructions {
+		if !inst.IsJump() {
+			continue
+		}
+		if target := inst.Target(); target >= len(boundaries) || !boundaries[target] {
+			return fmt.Errorf("%w: invalid jump target %d at %d", ErrInvalidProgram, target, inst.Offset)
+		}
+	}
+
+	depths := make([]int, len(code)+1)
+	for i := range depths {
+		depths[i] = -1
+	}
+	depths[0] = 0
+
+	merge := func(target, depth int) error {
+		if depths[target] >= 0 && depths[target] != depth {
+			return fmt.Errorf("%w: inconsistent stack depth at %d, %d and %d", ErrInvalidProgram, target, depths[target], depth)
+		}
+		depths[target] = depth
+		return nil
+	}
+
+	maxDepth := 0
+	for _, inst := range instructions {
+		depth := depths[inst.Offset]
+		if depth < 0 {
+			continue
+		}
+
+		pops, pushes := stackEffect(program, inst)
+		if depth < pops {
+			return fmt.Errorf("%w: stack underflow at %d, opcode %v needs %d values but stack depth is %d", ErrInvalidProgram, inst.Offset, OpCode(inst.OpCode), pops, depth)
+		}
+		depth += pushes - pops
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+
+		if inst.IsJump() {
+			if err := merge(inst.Target(), depth); err != nil {
+				return err
+			}
+		}
+		if inst.OpCode != OpCodeJump {
+			if err := merge(inst.Offset+inst.Width, depth); err != nil {
+				return err
+			}
+		}
+	}
+
+	if limit := program.limits.MaxStackDepth; limit > 0 && maxDepth > limit {
+		return &limitError{err: &StackLimitError{Limit: limit}, detail: fmt.Sprintf("by depth %d", maxDepth)}
+	}
+	if depth := depths[len(code)]; depth != 1 {
+		return fmt.Errorf("%w: stack depth %d at end of program", ErrInvalidProgram, depth)
+	}
+	return nil
+}
+
+func verifyOperands(program *Program, inst Instruction) error {
+	for i, operand := range Operands(inst.OpCode) {
+		switch operand {
+		case OperandConstant:
+			index := inst.Operands[i]
+			if index >= len(program.constants) {
+				return fmt.Errorf("%w: constant index %d out of range at %d", ErrInvalidProgram, index, inst.Offset)
+			}
+
+			constant := program.constants[index]
+			valid := true
+			switch inst.OpCode {
+			case OpCodeCall, OpCodeMethod:
+				call, ok := constant.(Call)
+				valid = ok && call.ArgumentsCnt >= 0
+			case OpCodeFetch, OpCodeProperty:
+				_, valid = constant.(string)
+			case OpCodeFetchPath:
+				valid = isPath(constant)
+			}
+			if !valid {
+				return fmt.Errorf("%w: invalid constant %v of type %T for opcode %v at %d", ErrInvalidProgram, constant, constant, OpCode(inst.OpCode), inst.Offset)
+			}
+
+			switch inst.OpCode {
+			case OpCodePush, OpCodeAddConst, OpCodeCompareConst:
+				if limit, size := program.limits.MaxAllocation, constantSize(constant); limit > 0 && size > limit {
+					return &limitError{
+						err:    &AllocationLimitError{Limit: limit, Size: size},
+						detail: fmt.Sprintf("for constant %d at %d", index, inst.Offset),
+					}
+				}
+			}
+
+		case OperandOpCode:
+			switch op := byte(inst.Operands[i]); op {
+			case OpCodeEqual, OpCodeNotEqual, OpCodeLess, OpCodeGreater, OpCodeLessEqual, OpCodeGreaterEqual:
+			default:
+				return fmt.Errorf("%w: invalid comparison opcode %v at %d", ErrInvalidProgram, OpCode(op), inst.Offset)
+			}
+		}
+	}
+	return nil
+}
+
+type limitError struct {
+	err    error
+	detail string
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("%v: %v %s", ErrInvalidProgram, e.err, e.detail)
+}
+
+func (e *limitError) Unwrap() error { return e.err }
+
+func (e *limitError) Is(target error) bool { return target == ErrInvalidProgram }
+
+func constantSize(constant interface{}) int {
+	size := 0
+	switch v := constant.(type) {
+	case string:
+		size = len(v)
+	case []interface{}:
+		size = len(v)
+		for _, item := range v {
+			if n := constantSize(item); n > size {
+				size = n
+			}
+		}
+	case map[interface{}]interface{}:
+		size = len(v)
+		for key, value := range v {
+			if n := constantSize(key); n > size {
+				size = n
+			}
+			if n := constantSize(value); n > size {
+				size = n
+			}
+		}
+	}
+	return size
+}
+
+func isPath(constant interface{}) bool {
+	path, ok := constant.([]interface{})
+	if !ok || len(path) == 0 {
+		return false
+	}
+	for _, name := range path {
+		if _, ok := name.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func stackEffect(program *Program, inst Instruction) (int, int) {
+	switch inst.OpCode {
+	case OpCodePush, OpCodeFetch, OpCodeTrue, OpCodeFalse, OpCodeNil, OpCodeFetchPath:
+		return 0, 1
+	case OpCodePop:
+		return 1, 0
+	case OpCodeNot, OpCodeNegate, OpCodeProperty, OpCodeAddConst, OpCodeCompareConst:
+		return 1, 1
+	case OpCodeJump:
+		return 0, 0
+	case OpCodeJumpIfFalse, OpCodeJumpIfTrue:
+		return 1, 1
+	case OpCodeSlice:
+		return 3, 1
+	case OpCodeArray:
+		return inst.Operands[0], 1
+	case OpCodeMap:
+		return 2 * inst.Operands[0], 1
+	case OpCodeCall:
+		return program.constants[inst.Operands[0]].(Call).ArgumentsCnt, 1
+	case OpCodeMethod:
+		return program.constants[inst.Operands[0]].(Call).ArgumentsCnt + 1, 1
+	default:
+		return 2, 1
+	}
+}
@@ -0,0 +1,66 @@
+package runtime
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	program := NewProgram([]byte{
+		OpCodeFetch, 0x00, 0x00,
+		OpCodeJumpIfFalse, 0x00, 0x07,
+		OpCodePop,
+		OpCodePush, 0x00, 0x01,
+		OpCodeCall, 0x00, 0x02,
+		OpCodeArray, 0x00, 0x01,
+	}, []interface{}{"x", 1, Call{Name: "f", ArgumentsCnt: 1}})
+	assert.Nil(t, Verify(program))
+
+	cases := []struct {
+		instructions []byte
+		constants    []interface{}
+		expect       string
+	}{
+		{nil, nil, "invalid program: empty program"},
+		{[]byte{0xff}, nil, "invalid program: unknown opcode 255 at 0"},
+		{[]byte{OpCodePush, 0x00}, nil, "invalid program: truncated instruction at 0"},
+		{[]byte{OpCodeWide, OpCodePush, 0x00, 0x00}, []interface{}{1}, "invalid program: truncated instruction at 0"},
+		{[]byte{OpCodeTrue, OpCodeTrue, OpCodeWide, OpCodeAdd}, nil, "invalid program: invalid wide prefix for Add at 2"},
+		{[]byte{OpCodeWide}, nil, "invalid program: truncated instruction at 0"},
+		{[]byte{OpCodePush, 0x00, 0x01}, []interface{}{1}, "invalid program: constant index 1 out of range at 0"},
+		{[]byte{OpCodeCall, 0x00, 0x00}, []interface{}{"f"}, "invalid program: invalid constant f of type string for opcode Call at 0"},
+		{[]byte{OpCodeFetchPath, 0x00, 0x00}, []interface{}{[]interface{}{"a", 1}}, "invalid program: invalid constant [a 1] of type []interface {} for opcode FetchPath at 0"},
+		{[]byte{OpCodeTrue, OpCodeCompareConst, 0x00, 0x00, OpCodeAdd}, []interface{}{1}, "invalid program: invalid comparison opcode Add at 1"},
+		{[]byte{OpCodeTrue, OpCodeJump, 0x00, 0x02, OpCodePush, 0x00, 0x00}, []interface{}{1}, "invalid program: invalid jump target 6 at 1"},
+		{[]byte{OpCodeTrue, OpCodeJump, 0x00, 0x05}, nil, "invalid program: invalid jump target 9 at 1"},
+		{[]byte{OpCodeTrue, OpCodeAdd}, nil, "invalid program: stack underflow at 1, opcode Add needs 2 values but stack depth is 1"},
+		{[]byte{OpCodeTrue, OpCodeJumpIfTrue, 0x00, 0x01, OpCodePop}, nil, "invalid program: inconsistent stack depth at 5, 1 and 0"},
+		{[]byte{OpCodeTrue, OpCodeTrue}, nil, "invalid program: stack depth 2 at end of program"},
+	}
+
+	for _, c := range cases {
+		assert.EqualError(t, Verify(NewProgram(c.instructions, c.constants)), c.expect)
+	}
+
+	limited := NewProgram([]byte{OpCodeTrue, OpCodeTrue, OpCodeEqual}, nil, WithLimits(Limits{MaxStackDepth: 1}))
+	assert.EqualError(t, Verify(limited), "invalid program: stack depth limit 1 exceeded by depth 2")
+	err := Verify(limited)
+	assert.True(t, errors.Is(err, ErrInvalidProgram))
+	assert.True(t, errors.As(err, new(*StackLimitError)))
+
+	allocation := WithLimits(Limits{MaxAllocation: 2})
+	assert.Nil(t, Verify(NewProgram([]byte{OpCodePush, 0x00, 0x00}, []interface{}{"ab"}, allocation)))
+	assert.Nil(t, Verify(NewProgram([]byte{OpCodeFetch, 0x00, 0x00}, []interface{}{"name"}, allocation)))
+	assert.EqualError(t, Verify(NewProgram([]byte{OpCodePush, 0x00, 0x00}, []interface{}{"abc"}, allocation)),
+		"invalid program: allocation of 3 exceeds limit 2 for constant 0 at 0")
+	assert.EqualError(t, Verify(NewProgram([]byte{OpCodePush, 0x00, 0x00}, []interface{}{[]interface{}{1, []interface{}{1, 2, 3}}}, allocation)),
+		"invalid program: allocation of 3 exceeds limit 2 for constant 0 at 0")
+	assert.EqualError(t, Verify(NewProgram([]byte{OpCodeTrue, OpCodeAddConst, 0x00, 0x00}, []interface{}{map[interface{}]interface{}{1: 1, 2: 2, 3: 3}}, allocation)),
+		"invalid program: allocation of 3 exceeds limit 2 for constant 0 at 1")
+
+	err = Verify(NewProgram([]byte{OpCodePush, 0x00, 0x00}, []interface{}{"abc"}, allocation))
+	assert.True(t, errors.Is(err, ErrInvalidProgram))
+	assert.True(t, errors.As(err, new(*AllocationLimitError)))
+}
@@ -0,0 +1,868 @@
+package runtime
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/gscienty/causer/expr/file"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+type VM struct {
+	stack   []interface{}
+	program *Program
+
+	instructionPointer int
+	op                 byte
+	wide               bool
+
+	instFunc [256]func() error
+
+	env interface{}
+}
+
+const (
+	runtimeOpAdd = "+"
+	runtimeOpSub = "-"
+	runtimeOpMul = "*"
+	runtimeOpDiv = "/"
+	runtimeOpMod = "%"
+	runtimeOpPow = "^"
+
+	runtimeOpEqual        = "=="
+	runtimeOpNotEqual     = "!="
+	runtimeOpLess         = "<"
+	runtimeOpGreater      = ">"
+	runtimeOpLessEqual    = "<="
+	runtimeOpGreaterEqual = ">="
+	runtimeOpIn           = "in"
+)
+
+var vmPool = sync.Pool{
+	New: func() interface{} { return NewVM() },
+}
+
+const cancelCheckInterval = 1024
+
+func Run(program *Program, env interface{}) (interface{}, error) {
+	return RunContext(context.Background(), program, env)
+}
+
+func RunContext(ctx context.Context, program *Program, env interface{}) (interface{}, error) {
+	vm := vmPool.Get().(*VM)
+	defer vmPool.Put(vm)
+
+	return vm.RunContext(ctx, program, env)
+}
+
+func NewVM() *VM {
+	vm := &VM{
+		stack: make([]interface{}, 0, 16),
+	}
+
+	vm.instFunc = [256]func() error{
+		OpCodeAdd:      vm.instBinaryOp(runtimeOpAdd),
+		OpCodeSub:      vm.instBinaryOp(runtimeOpSub),
+		OpCodeMul:      vm.instBinaryOp(runtimeOpMul),
+		OpCodeDiv:      vm.instBinaryOp(runtimeOpDiv),
+		OpCodeMod:      vm.instBinaryOp(runtimeOpMod),
+		OpCodePow:      vm.instBinaryOp(runtimeOpPow),
+		OpCodePop:      vm.instPop,
+		OpCodePush:     vm.instPush,
+		OpCodeCall:     vm.instCall,
+		OpCodeFetch:    vm.instFetch,
+		OpCodeProperty: vm.instProperty,
+
+		OpCodeEqual:        vm.instEqual(runtimeOpEqual, true),
+		OpCodeNotEqual:     vm.instEqual(runtimeOpNotEqual, false),
+		OpCodeLess:         vm.instCompare(runtimeOpLess, func(c int) bool { return c < 0 }),
+		OpCodeGreater:      vm.instCompare(runtimeOpGreater, func(c int) bool { return c > 0 }),
+		OpCodeLessEqual:    vm.instCompare(runtimeOpLessEqual, func(c int) bool { return c <= 0 }),
+		OpCodeGreaterEqual: vm.instCompare(runtimeOpGreaterEqual, func(c int) bool { return c >= 0 }),
+
+		OpCodeJump:        vm.instJump,
+		OpCodeJumpIfFalse: vm.instJumpIf(false),
+		OpCodeJumpIfTrue:  vm.instJumpIf(true),
+
+		OpCodeArray: vm.instArray,
+		OpCodeMap:   vm.instMap,
+
+		OpCodeIn:     vm.instIn,
+		OpCodeNot:    vm.instNot,
+		OpCodeNegate: vm.instNegate,
+		OpCodeTrue:   vm.instBool(true),
+		OpCodeFalse:  vm.instBool(false),
+		OpCodeNil:    vm.instNil,
+
+		OpCodeIndex: vm.instIndex,
+		OpCodeSlice: vm.instSlice,
+
+		OpCodeMethod: vm.instMethod,
+
+		OpCodeFetchPath:    vm.instFetchPath,
+		OpCodeAddConst:     vm.instAddConst,
+		OpCodeCompareConst: vm.instCompareConst,
+		OpCodeWide:         vm.instWide,
+	}
+
+	return vm
+}
+
+func (vm *VM) readArg() int {
+	if vm.wide {
+		ret := binary.BigEndian.Uint32(vm.program.instructions[vm.instructionPointer : vm.instructionPointer+4])
+		vm.instructionPointer += 4
+		return int(ret)
+	}
+
+	ret := binary.BigEndian.Uint16(vm.program.instructions[vm.instructionPointer : vm.instructionPointer+2])
+	vm.instructionPointer += 2
+	return int(ret)
+}
+
+func (vm *VM) readConstant() interface{} { return vm.program.constants[vm.readArg()] }
+
+func (vm *VM) push(v interface{}) { vm.stack = append(vm.stack, v) }
+func (vm *VM) peek() interface{} {
+	if len(vm.stack) == 0 {
+		panic(ErrStackUnderflow)
+	}
+	return vm.stack[len(vm.stack)-1]
+}
+
+func (vm *VM) pop() interface{} {
+	if len(vm.stack) == 0 {
+		panic(ErrStackUnderflow)
+	}
+	v := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return v
+}
+
+func (vm *VM) Run(program *Program, env interface{}) (interface{}, error) {
+	return vm.RunContext(context.Background(), program, env)
+}
+
+func (vm *VM) RunContext(ctx context.Context, program *Program, env interface{}) (ret interface{}, err error) {
+	vm.program = program
+	vm.env = env
+	vm.instructionPointer = 0
+	vm.stack = vm.stack[:0]
+
+	defer vm.reset()
+
+	if err := checkEnv(program.envType, env); err != nil {
+		return nil, err
+	}
+
+	var ip int
+	var steps int
+
+	done := ctx.Done()
+	limits := program.limits
+
+	var maxDepth int
+	if debug := program.debug; debug != nil {
+		defer func() {
+			debug(Trace{Steps: steps, StackDepth: len(vm.stack), MaxStackDepth: maxDepth})
+		}()
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			e, ok := p.(error)
+			if !ok {
+				e = fmt.Errorf("%v", p)
+			}
+			ret, err = nil, vm.newError(vm.op, ip, e)
+		}
+	}()
+
+	for vm.instructionPointer < len(program.instructions) {
+		ip = vm.instructionPointer
+		vm.op = program.instructions[vm.instructionPointer]
+		vm.instructionPointer++
+
+		if done != nil && steps%cancelCheckInterval == 0 {
+			select {
+			case <-done:
+				return nil, vm.newError(vm.op, ip, ctx.Err())
+			default:
+			}
+		}
+
+		steps++
+		if limits.MaxSteps > 0 && steps > limits.MaxSteps {
+			return nil, vm.newError(vm.op, ip, &StepLimitError{Limit: limits.MaxSteps})
+		}
+
+		instFunc := vm.instFunc[vm.op]
+		if instFunc == nil {
+			return nil, vm.newError(vm.op, ip, fmt.Errorf("unexcepted instruction"))
+		}
+		if err := instFunc(); err != nil {
+			return nil, vm.newError(vm.op, ip, err)
+		}
+
+		if len(vm.stack) > maxDepth {
+			maxDepth = len(vm.stack)
+		}
+		if limits.MaxStackDepth > 0 && len(vm.stack) > limits.MaxStackDepth {
+			return nil, vm.newError(vm.op, ip, &StackLimitError{Limit: limits.MaxStackDepth})
+		}
+	}
+
+	switch n := len(vm.stack); {
+	case n == 0:
+		return nil, vm.newError(vm.op, ip, ErrStackUnderflow)
+	case n > 1:
+		return nil, vm.newError(vm.op, ip, fmt.Errorf("%w: stack depth %d at end of program", ErrStackLeftover, n))
+	}
+	return vm.stack[0], nil
+}
+
+func checkEnv(envType reflect.Type, env interface{}) error {
+	if envType == nil {
+		return nil
+	}
+
+	t := reflect.TypeOf(env)
+	if t != nil && (t.AssignableTo(envType) || (t.Kind() == reflect.Ptr && t.Elem().AssignableTo(envType))) {
+		return nil
+	}
+	return fmt.Errorf("env of type %v does not match declared %v", t, envType)
+}
+
+func (vm *VM) reset() {
+	for i := range vm.stack {
+		vm.stack[i] = nil
+	}
+	vm.stack = vm.stack[:0]
+	vm.program = nil
+	vm.env = nil
+	vm.wide = false
+}
+
+func (vm *VM) newError(op byte, ip int, err error) *Error {
+	e := &Error{
+		OpCode:             OpCode(op),
+		InstructionPointer: ip,
+		StackDepth:         len(vm.stack),
+		Err:                err,
+	}
+
+	if location, ok := vm.program.Location(ip); ok {
+		e.Line = location.Start.Line
+		e.Column = location.Start.Offset + 1
+		e.Snippet = file.Snippet(vm.program.source, location.Start, location.End)
+	}
+	return e
+}
+
+func (vm *VM) callImpl(op string, left, right interface{}) (interface{}, bool) {
+	impls, ok := vm.program.operators[op]
+	if !ok {
+		return nil, false
+	}
+
+	leftType, rightType := reflect.TypeOf(left), reflect.TypeOf(right)
+	if leftType == nil || rightType == nil {
+		return nil, false
+	}
+
+	for _, impl := range impls {
+		if _, ok := MatchOperator(impl, leftType, rightType); !ok {
+			continue
+		}
+
+		ret := reflect.ValueOf(impl).Call([]reflect.Value{reflect.ValueOf(left), reflect.ValueOf(right)})
+		return ret[0].Interface(), true
+	}
+
+	return nil, false
+}
+
+func (vm *VM) binaryOp(op string, left, right interface{}) error {
+	if ret, ok := vm.callImpl(op, left, right); ok {
+		vm.push(ret)
+		return nil
+	}
+
+	ret, err := arithmetic(op, left, right)
+	if err != nil {
+		return err
+	}
+	if s, ok := ret.(string); ok {
+		if err := vm.allocate(len(s)); err != nil {
+			return err
+		}
+	}
+	vm.push(ret)
+	return nil
+}
+
+func (vm *VM) allocate(size int) error {
+	if limit := vm.program.limits.MaxAllocation; limit > 0 && size > limit {
+		return &AllocationLimitError{Limit: limit, Size: size}
+	}
+	return nil
+}
+
+func (vm *VM) instBinaryOp(op string) func() error {
+	return func() error {
+		right := vm.pop()
+		return vm.binaryOp(op, vm.pop(), right)
+	}
+}
+
+func (vm *VM) instAddConst() error {
+	return vm.binaryOp(runtimeOpAdd, vm.pop(), vm.readConstant())
+}
+
+func (vm *VM) equalOp(op string, expect bool, left, right interface{}) error {
+	if ret, ok := vm.callImpl(op, left, right); ok {
+		vm.push(ret)
+		return nil
+	}
+	vm.push(equal(left, right) == expect)
+	return nil
+}
+
+func (vm *VM) instEqual(op string, expect bool) func() error {
+	return func() error {
+		right := vm.pop()
+		return vm.equalOp(op, expect, vm.pop(), right)
+	}
+}
+
+func (vm *VM) compareOp(op string, test func(int) bool, left, right interface{}) error {
+	if ret, ok := vm.callImpl(op, left, right); ok {
+		vm.push(ret)
+		return nil
+	}
+
+	c, err := compare(left, right)
+	if err != nil {
+		return err
+	}
+	vm.push(test(c))
+	return nil
+}
+
+func (vm *VM) instCompare(op string, test func(int) bool) func() error {
+	return func() error {
+		right := vm.pop()
+		return vm.compareOp(op, test, vm.pop(), right)
+	}
+}
+
+func (vm *VM) instCompareConst() error {
+	right := vm.readConstant()
+	op := vm.program.instructions[vm.instructionPointer]
+	vm.instructionPointer++
+	left := vm.pop()
+
+	switch op {
+	case OpCodeEqual:
+		return vm.equalOp(runtimeOpEqual, true, left, right)
+	case OpCodeNotEqual:
+		return vm.equalOp(runtimeOpNotEqual, false, left, right)
+	case OpCodeLess:
+		return vm.compareOp(runtimeOpLess, func(c int) bool { return c < 0 }, left, right)
+	case OpCodeGreater:
+		return vm.compareOp(runtimeOpGreater, func(c int) bool { return c > 0 }, left, right)
+	case OpCodeLessEqual:
+		return vm.compareOp(runtimeOpLessEqual, func(c int) bool { return c <= 0 }, left, right)
+	case OpCodeGreaterEqual:
+		return vm.compareOp(runtimeOpGreaterEqual, func(c int) bool { return c >= 0 }, left, right)
+	}
+	return fmt.Errorf("invalid comparison opcode %v", OpCode(op))
+}
+
+func (vm *VM) instPop() error {
+	vm.pop()
+	return nil
+}
+
+func (vm *VM) instPush() error {
+	vm.push(copyConstant(vm.readConstant()))
+	return nil
+}
+
+func copyConstant(constant interface{}) interface{} {
+	switch v := constant.(type) {
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, item := range v {
+			array[i] = copyConstant(item)
+		}
+		return array
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			m[key] = copyConstant(value)
+		}
+		return m
+	}
+	return constant
+}
+
+func (vm *VM) instIn() error {
+	right := vm.pop()
+	left := vm.pop()
+
+	if ret, ok := vm.callImpl(runtimeOpIn, left, right); ok {
+		vm.push(ret)
+		return nil
+	}
+
+	ret, err := contains(right, left)
+	if err != nil {
+		return err
+	}
+	vm.push(ret)
+	return nil
+}
+
+func (vm *VM) instNot() error {
+	vm.push(!Truthy(vm.pop()))
+	return nil
+}
+
+func (vm *VM) instNegate() error {
+	v := vm.pop()
+
+	value := reflect.ValueOf(v)
+	switch {
+	case isInteger(value.Kind()):
+		vm.push(reflect.ValueOf(-value.Int()).Convert(value.Type()).Interface())
+		return nil
+	case isUnsigned(value.Kind()):
+		i, err := toInt64(value)
+		if err != nil {
+			return err
+		}
+		vm.push(-i)
+		return nil
+	case isFloat(value.Kind()):
+		vm.push(reflect.ValueOf(-value.Float()).Convert(value.Type()).Interface())
+		return nil
+	}
+	return fmt.Errorf("invalid operation: -%T", v)
+}
+
+func (vm *VM) instBool(v bool) func() error {
+	return func() error {
+		vm.push(v)
+		return nil
+	}
+}
+
+func (vm *VM) instJump() error {
+	offset := vm.readArg()
+	vm.instructionPointer += offset
+	return nil
+}
+
+func (vm *VM) instJumpIf(expect bool) func() error {
+	return func() error {
+		offset := vm.readArg()
+		if Truthy(vm.peek()) == expect {
+			vm.instructionPointer += offset
+		}
+		return nil
+	}
+}
+
+func (vm *VM) instArray() error {
+	size := vm.readArg()
+	if err := vm.allocate(size); err != nil {
+		return err
+	}
+	array := make([]interface{}, size)
+	for i := size - 1; i >= 0; i-- {
+		array[i] = vm.pop()
+	}
+	vm.push(array)
+	return nil
+}
+
+func (vm *VM) instMap() error {
+	size := vm.readArg()
+	if err := vm.allocate(size); err != nil {
+		return err
+	}
+	pairs := make([]interface{}, 2*size)
+	for i := 2*size - 1; i >= 0; i-- {
+		pairs[i] = vm.pop()
+	}
+
+	m := make(map[interface{}]interface{}, size)
+	for i := 0; i < size; i++ {
+		key := pairs[2*i]
+		if key != nil && !reflect.TypeOf(key).Comparable() {
+			return fmt.Errorf("invalid map key type %T", key)
+		}
+		m[key] = pairs[2*i+1]
+	}
+	vm.push(m)
+	return nil
+}
+
+func (vm *VM) instProperty() error {
+	instance := vm.pop()
+	prop := vm.readConstant()
+	v, err := vm.fetch(instance, prop)
+	if err != nil {
+		return err
+	}
+	vm.push(v)
+	return nil
+}
+
+func (vm *VM) instFetch() error {
+	v, err := vm.fetch(vm.env, vm.readConstant())
+	if err != nil {
+		return err
+	}
+	vm.push(v)
+	return nil
+}
+
+func (vm *VM) instWide() error {
+	op := vm.program.instructions[vm.instructionPointer]
+	vm.instructionPointer++
+
+	instFunc := vm.instFunc[op]
+	if op == OpCodeWide || instFunc == nil {
+		return fmt.Errorf("invalid wide prefix for %v", OpCode(op))
+	}
+
+	vm.op = op
+	vm.wide = true
+	err := instFunc()
+	vm.wide = false
+	return err
+}
+
+func (vm *VM) instFetchPath() error {
+	path := vm.readConstant().([]interface{})
+
+	v, err := vm.fetch(vm.env, path[0])
+	for _, prop := range path[1:] {
+		if err != nil {
+			break
+		}
+		v, err = vm.fetch(v, prop)
+	}
+	if err != nil {
+		return err
+	}
+	vm.push(v)
+	return nil
+}
+
+func (vm *VM) instIndex() error {
+	index := vm.pop()
+	instance := vm.pop()
+
+	value := reflect.ValueOf(instance)
+	if value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Invalid, reflect.Array, reflect.Slice, reflect.String, reflect.Map:
+	case reflect.Ptr:
+		if !value.IsNil() {
+			return fmt.Errorf("cannot index %T", instance)
+		}
+	case reflect.Struct:
+		if _, ok := index.(string); !ok {
+			return fmt.Errorf("invalid index %v of type %T", index, index)
+		}
+	default:
+		return fmt.Errorf("cannot index %T", instance)
+	}
+	if value.IsValid() {
+		instance = value.Interface()
+	}
+
+	v, err := vm.fetch(instance, index)
+	if err != nil {
+		return err
+	}
+	vm.push(v)
+	return nil
+}
+
+func (vm *VM) instSlice() error {
+	to := vm.pop()
+	from := vm.pop()
+	instance := vm.pop()
+	v, err := vm.slice(instance, from, to)
+	if err != nil {
+		return err
+	}
+	vm.push(v)
+	return nil
+}
+
+func (vm *VM) instNil() error {
+	vm.push(nil)
+	return nil
+}
+
+func (vm *VM) popArguments(n int) []reflect.Value {
+	in := make([]reflect.Value, n)
+	for i := n; i > 0; i-- {
+		param := vm.pop()
+		if param == nil && reflect.TypeOf(param) == nil {
+			in[i-1] = reflect.ValueOf(&param).Elem()
+		} else {
+			in[i-1] = reflect.ValueOf(param)
+		}
+	}
+	return in
+}
+
+func (vm *VM) call(name string, fn reflect.Value, in []reflect.Value) error {
+	in, err := adaptArguments(name, fn.Type(), in)
+	if err != nil {
+		return err
+	}
+
+	out := fn.Call(in)
+	if n := len(out); n > 0 && out[n-1].Type() == errorType {
+		if !out[n-1].IsNil() {
+			return out[n-1].Interface().(error)
+		}
+		out = out[:n-1]
+	}
+
+	if len(out) == 0 {
+		vm.push(nil)
+	} else {
+		vm.push(out[0].Interface())
+	}
+	return nil
+}
+
+func (vm *VM) instCall() error {
+	call := vm.readConstant().(Call)
+	in := vm.popArguments(call.ArgumentsCnt)
+
+	fn := vm.fetchFn(call.Name)
+	if fn == nil {
+		return fmt.Errorf("unknown function %s", call.Name)
+	}
+	return vm.call(call.Name, *fn, in)
+}
+
+func (vm *VM) instMethod() error {
+	call := vm.readConstant().(Call)
+	in := vm.popArguments(call.ArgumentsCnt)
+	receiver := vm.pop()
+
+	fn := vm.fetchMethod(receiver, call.Name)
+	if fn == nil {
+		return fmt.Errorf("unknown method %s of %T", call.Name, receiver)
+	}
+	return vm.call(call.Name, *fn, in)
+}
+
+func (vm *VM) fetchMethod(receiver interface{}, name string) *reflect.Value {
+	v := reflect.ValueOf(receiver)
+	if !v.IsValid() {
+		return nil
+	}
+
+	if method := v.MethodByName(name); method.IsValid() {
+		return &method
+	}
+	if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface {
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		if method := ptr.MethodByName(name); method.IsValid() {
+			return &method
+		}
+	}
+
+	field, err := vm.fetch(receiver, name)
+	if err != nil || field == nil {
+		return nil
+	}
+	if fn := reflect.ValueOf(field); fn.Kind() == reflect.Func && !fn.IsNil() {
+		return &fn
+	}
+	return nil
+}
+
+func (vm *VM) fetchFn(name string) *reflect.Value {
+	if fn, ok := vm.program.functions[name]; ok && fn.Kind() == reflect.Func && !fn.IsNil() {
+		return &fn
+	}
+
+	v := reflect.ValueOf(vm.env)
+	if !v.IsValid() {
+		return nil
+	}
+
+	if v.NumMethod() > 0 {
+		method := v.MethodByName(name)
+		if method.IsValid() {
+			return &method
+		}
+	}
+
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	var ret reflect.Value
+	switch v.Kind() {
+	case reflect.Map:
+		if key, ok := mapKey(v.Type().Key(), name); ok {
+			ret = v.MapIndex(key)
+		}
+	case reflect.Struct:
+		ret = v.FieldByName(name)
+	}
+
+	if ret.IsValid() && ret.Kind() == reflect.Interface {
+		ret = ret.Elem()
+	}
+	if !ret.IsValid() || ret.Kind() != reflect.Func || ret.IsNil() || !ret.CanInterface() {
+		return nil
+	}
+	return &ret
+}
+
+func (vm *VM) fetch(env interface{}, identifiy interface{}) (interface{}, error) {
+	envValue := reflect.ValueOf(env)
+
+	if envValue.Kind() == reflect.Ptr && reflect.Indirect(envValue).Kind() == reflect.Struct {
+		envValue = reflect.Indirect(envValue)
+	}
+
+	switch envValue.Kind() {
+	case reflect.Array, reflect.Slice, reflect.String:
+		var runes []rune
+		length := envValue.Len()
+		if envValue.Kind() == reflect.String {
+			runes = []rune(envValue.String())
+			length = len(runes)
+		}
+
+		index, ok := toIndex(identifiy)
+		if !ok {
+			return nil, fmt.Errorf("invalid index %v of type %T", identifiy, identifiy)
+		}
+		if index < 0 {
+			index += length
+		}
+		if index < 0 || index >= length {
+			return nil, fmt.Errorf("index out of range [%v] with length %d", identifiy, length)
+		}
+
+		if envValue.Kind() == reflect.String {
+			return string(runes[index]), nil
+		}
+		v := envValue.Index(index)
+		if v.IsValid() && v.CanInterface() {
+			return v.Interface(), nil
+		}
+
+	case reflect.Map:
+		key, ok := mapKey(envValue.Type().Key(), identifiy)
+		if !ok {
+			return nil, fmt.Errorf("invalid map key %v of type %T", identifiy, identifiy)
+		}
+		v := envValue.MapIndex(key)
+		if v.IsValid() {
+			if v.CanInterface() {
+				return v.Interface(), nil
+			} else {
+				return reflect.Zero(reflect.TypeOf(env).Elem()).Interface(), nil
+			}
+		}
+
+	case reflect.Struct:
+		v := envValue.FieldByName(reflect.ValueOf(identifiy).String())
+		if v.IsValid() && v.CanInterface() {
+			return v.Interface(), nil
+		}
+	}
+
+	return nil, nil
+}
+
+func toIndex(v interface{}) (int, bool) {
+	value := reflect.ValueOf(v)
+	switch {
+	case isInteger(value.Kind()):
+		i := value.Int()
+		return int(i), int64(int(i)) == i
+	case isUnsigned(value.Kind()):
+		u := value.Uint()
+		return int(u), u <= uint64(maxInt)
+	}
+	return 0, false
+}
+
+func (vm *VM) slice(instance, from, to interface{}) (interface{}, error) {
+	value := reflect.ValueOf(instance)
+
+	switch value.Kind() {
+	case reflect.Array, reflect.Slice, reflect.String:
+	default:
+		return nil, fmt.Errorf("cannot slice %T", instance)
+	}
+
+	var runes []rune
+	length := value.Len()
+	if value.Kind() == reflect.String {
+		runes = []rune(value.String())
+		length = len(runes)
+	}
+
+	bound := func(v interface{}, def int) (int, error) {
+		if v == nil {
+			return def, nil
+		}
+		i, ok := toIndex(v)
+		if !ok {
+			return 0, fmt.Errorf("invalid slice index %v of type %T", v, v)
+		}
+		if i < 0 {
+			i += length
+		}
+		return i, nil
+	}
+
+	lo, err := bound(from, 0)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := bound(to, length)
+	if err != nil {
+		return nil, err
+	}
+	if lo < 0 || hi < lo || hi > length {
+		return nil, fmt.Errorf("slice bounds out of range [%v:%v] with length %d", from, to, length)
+	}
+
+	if value.Kind() == reflect.String {
+		return string(runes[lo:hi]), nil
+	}
+
+	if value.Kind() == reflect.Array && !value.CanAddr() {
+		addressable := reflect.New(value.Type()).Elem()
+		addressable.Set(value)
+		value = addressable
+	}
+	return value.Slice(lo, hi).Interface(), nil
+}
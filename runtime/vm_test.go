@@ -0,0 +1,199 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/gscienty/causer/expr/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVMConcurrent(t *testing.T) {
+	program := NewProgram([]byte{
+		OpCodeFetch, 0x00, 0x00,
+		OpCodePush, 0x00, 0x01,
+		OpCodeMul,
+		OpCodePush, 0x00, 0x02,
+		OpCodeAdd,
+	}, []interface{}{"x", 2, 1})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				ret, err := Run(program, map[string]int{"x": i * j})
+				assert.Nil(t, err)
+				assert.Equal(t, 2*i*j+1, ret)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestVMReuse(t *testing.T) {
+	vm := NewVM()
+
+	failing := NewProgram([]byte{
+		OpCodePush, 0x00, 0x00,
+		OpCodePush, 0x00, 0x01,
+		OpCodeSub,
+	}, []interface{}{"a", 1})
+	_, err := vm.Run(failing, nil)
+	assert.NotNil(t, err)
+
+	ret, err := vm.Run(NewProgram([]byte{OpCodeTrue}, nil), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, true, ret)
+	assert.Equal(t, 0, len(vm.stack))
+}
+
+func TestProgramWith(t *testing.T) {
+	type money struct{ Cents int }
+
+	program := NewProgram([]byte{
+		OpCodePush, 0x00, 0x00,
+		OpCodePush, 0x00, 0x00,
+		OpCodeAdd,
+	}, []interface{}{money{1}})
+
+	overloaded := program.With(WithOperator("+", func(left, right money) money { return money{left.Cents + right.Cents} }))
+
+	_, err := Run(program, nil)
+	assert.NotNil(t, err)
+
+	ret, err := Run(overloaded, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, money{2}, ret)
+}
+
+func TestRunContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	instructions := []byte{OpCodeCall, 0x00, 0x00}
+	for i := 0; i < 2*cancelCheckInterval; i++ {
+		instructions = append(instructions, OpCodeNil, OpCodePop)
+	}
+	program := NewProgram(instructions, []interface{}{Call{Name: "cancel"}}, WithFunction("cancel", cancel))
+
+	ret, err := RunContext(ctx, program, nil)
+	assert.Nil(t, ret)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 3+cancelCheckInterval-1, err.(*Error).InstructionPointer)
+
+	_, err = RunContext(ctx, NewProgram([]byte{OpCodeTrue}, nil), nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestRunLimits(t *testing.T) {
+	program := NewProgram([]byte{
+		OpCodePush, 0x00, 0x00,
+		OpCodePush, 0x00, 0x00,
+		OpCodeAdd,
+		OpCodePush, 0x00, 0x00,
+		OpCodeArray, 0x00, 0x02,
+	}, []interface{}{"ab"})
+
+	ret, err := Run(program, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"abab", "ab"}, ret)
+
+	cases := []struct {
+		limits Limits
+		check  func(err error) bool
+		expect string
+	}{
+		{Limits{MaxSteps: 4}, func(err error) bool { return errors.As(err, new(*StepLimitError)) }, "step limit 4 exceeded"},
+		{Limits{MaxStackDepth: 1}, func(err error) bool { return errors.As(err, new(*StackLimitError)) }, "stack depth limit 1 exceeded"},
+		{Limits{MaxAllocation: 3}, func(err error) bool { return errors.As(err, new(*AllocationLimitError)) }, "allocation of 4 exceeds limit 3"},
+	}
+
+	for _, c := range cases {
+		_, err := Run(program.With(WithLimits(c.limits)), nil)
+		assert.True(t, c.check(err), c.expect)
+		assert.EqualError(t, err.(*Error).Err, c.expect)
+	}
+
+	_, err = Run(program.With(WithLimits(Limits{MaxAllocation: 4})), nil)
+	assert.Nil(t, err)
+
+	array := NewProgram([]byte{OpCodeTrue, OpCodeTrue, OpCodeTrue, OpCodeArray, 0x00, 0x03}, nil, WithLimits(Limits{MaxAllocation: 2}))
+	_, err = Run(array, nil)
+	assert.EqualError(t, err, "allocation of 3 exceeds limit 2 (opcode Array at 3, stack depth 3)")
+}
+
+func TestRunStackValidation(t *testing.T) {
+	cases := []struct {
+		instructions []byte
+		target       error
+		expect       string
+	}{
+		{[]byte{OpCodeTrue, OpCodeAdd}, ErrStackUnderflow, "stack underflow (opcode Add at 1, stack depth 0)"},
+		{[]byte{OpCodeJumpIfTrue, 0x00, 0x00}, ErrStackUnderflow, "stack underflow (opcode JumpIfTrue at 0, stack depth 0)"},
+		{[]byte{OpCodeTrue, OpCodePop}, ErrStackUnderflow, "stack underflow (opcode Pop at 1, stack depth 0)"},
+		{[]byte{OpCodeTrue, OpCodeNil}, ErrStackLeftover, "values left on stack: stack depth 2 at end of program (opcode Nil at 1, stack depth 2)"},
+	}
+
+	for _, c := range cases {
+		_, err := Run(NewProgram(c.instructions, nil), nil)
+		assert.True(t, errors.Is(err, c.target), c.expect)
+		assert.EqualError(t, err, c.expect)
+	}
+}
+
+func TestRunDebug(t *testing.T) {
+	var trace Trace
+	program := NewProgram([]byte{
+		OpCodeTrue,
+		OpCodeTrue,
+		OpCodeTrue,
+		OpCodeArray, 0x00, 0x02,
+		OpCodeIn,
+	}, nil, WithDebug(func(t Trace) { trace = t }))
+
+	ret, err := Run(program, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, true, ret)
+	assert.Equal(t, Trace{Steps: 5, StackDepth: 1, MaxStackDepth: 3}, trace)
+
+	_, err = Run(program.With(WithInstructions([]byte{OpCodeTrue, OpCodeFalse}, nil)), nil)
+	assert.True(t, errors.Is(err, ErrStackLeftover))
+	assert.Equal(t, Trace{Steps: 2, StackDepth: 2, MaxStackDepth: 2}, trace)
+}
+
+func TestRunWide(t *testing.T) {
+	constants := make([]interface{}, 0x10001)
+	constants[0x10000] = "wide"
+
+	program := NewProgram([]byte{
+		OpCodeWide, OpCodeJump, 0x00, 0x00, 0x00, 0x02,
+		OpCodeNil,
+		OpCodePop,
+		OpCodeWide, OpCodePush, 0x00, 0x01, 0x00, 0x00,
+		OpCodeWide, OpCodeArray, 0x00, 0x00, 0x00, 0x01,
+	}, constants)
+	assert.Nil(t, Verify(program))
+
+	ret, err := Run(program, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"wide"}, ret)
+	assert.Contains(t, Disassemble(program), "0008  Push.wide   65536  \"wide\"")
+
+	_, err = Run(NewProgram([]byte{OpCodeTrue, OpCodeWide, OpCodeArray, 0x00, 0x00, 0x00, 0x02}, nil), nil)
+	var rtErr *Error
+	assert.True(t, errors.As(err, &rtErr))
+	assert.Equal(t, OpCode(OpCodeArray), rtErr.OpCode)
+	assert.EqualError(t, err, "stack underflow (opcode Array at 1, stack depth 0)")
+}
+
+func TestRunInvalidLocation(t *testing.T) {
+	program := NewProgram([]byte{OpCodeTrue, OpCodeAdd}, nil,
+		WithSource("x"),
+		WithLocations([]Location{{Offset: 0, Start: file.Position{Line: 1, Offset: -5}, End: file.Position{Line: 1, Offset: 1}}}),
+	)
+	_, err := Run(program, nil)
+	assert.True(t, errors.Is(err, ErrStackUnderflow))
+}